@@ -0,0 +1,309 @@
+package huobi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Credentials API访问凭证
+type Credentials struct {
+	AccessKey string
+	Signer    Signer
+}
+
+// CredentialProvider 凭证提供者
+// 每次发起签名请求前都会调用, 实现方可以在这里完成密钥轮换
+type CredentialProvider interface {
+	Credentials() (*Credentials, error)
+}
+
+// CredentialProviderFunc 函数形式的凭证提供者
+type CredentialProviderFunc func() (*Credentials, error)
+
+func (f CredentialProviderFunc) Credentials() (*Credentials, error) {
+	return f()
+}
+
+// StaticProvider 固定凭证
+type StaticProvider struct {
+	credentials Credentials
+}
+
+// NewStaticProvider 创建固定凭证提供者
+func NewStaticProvider(accessKey string, signer Signer) *StaticProvider {
+	return &StaticProvider{credentials: Credentials{AccessKey: accessKey, Signer: signer}}
+}
+
+func (p *StaticProvider) Credentials() (*Credentials, error) {
+	return &p.credentials, nil
+}
+
+// 环境变量名
+const (
+	EnvAccessKey  = "HUOBI_ACCESS_KEY"
+	EnvSecretKey  = "HUOBI_SECRET_KEY"
+	EnvPrivateKey = "HUOBI_PRIVATE_KEY" // PEM格式的ECDSA私钥, 设置后优先于Secret Key
+)
+
+// EnvProvider 从环境变量读取凭证, 每次调用都重新读取
+type EnvProvider struct{}
+
+// NewEnvProvider 创建环境变量凭证提供者
+func NewEnvProvider() *EnvProvider {
+	return &EnvProvider{}
+}
+
+func (p *EnvProvider) Credentials() (*Credentials, error) {
+	key := KeyFile{
+		AccessKey:  os.Getenv(EnvAccessKey),
+		SecretKey:  os.Getenv(EnvSecretKey),
+		PrivateKey: os.Getenv(EnvPrivateKey),
+	}
+	if key.AccessKey == "" {
+		return nil, errors.New(EnvAccessKey + " not set")
+	}
+
+	return key.credentials()
+}
+
+// KeyFile 凭证文件内容, 同时也是加密keystore中的明文
+type KeyFile struct {
+	AccessKey  string `json:"access_key"`
+	SecretKey  string `json:"secret_key,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"` // PEM格式的ECDSA私钥
+}
+
+// credentials 根据密钥类型构造签名器
+func (k *KeyFile) credentials() (*Credentials, error) {
+	if k.PrivateKey != "" {
+		signer, err := ParseECDSASigner([]byte(k.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		return &Credentials{AccessKey: k.AccessKey, Signer: signer}, nil
+	}
+
+	if k.SecretKey == "" {
+		return nil, errors.New("neither secret key nor private key provided")
+	}
+
+	return &Credentials{AccessKey: k.AccessKey, Signer: NewHmacSigner(k.SecretKey)}, nil
+}
+
+// fileCredentials 从文件加载凭证, 文件修改后自动重新加载
+type fileCredentials struct {
+	path  string
+	parse func(data []byte) (*KeyFile, error)
+
+	modTime     time.Time
+	size        int64
+	credentials *Credentials
+
+	mutex sync.Mutex
+}
+
+func (f *fileCredentials) Credentials() (*Credentials, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if f.credentials != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.credentials, nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := f.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := key.credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	f.credentials = credentials
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+
+	return credentials, nil
+}
+
+// FileProvider 从JSON凭证文件读取凭证, 替换文件即可轮换密钥
+type FileProvider struct {
+	fileCredentials
+}
+
+// NewFileProvider 创建文件凭证提供者, 文件格式见KeyFile
+func NewFileProvider(path string) *FileProvider {
+	p := &FileProvider{}
+	p.path = path
+	p.parse = func(data []byte) (*KeyFile, error) {
+		var key KeyFile
+		if err := json.Unmarshal(data, &key); err != nil {
+			return nil, err
+		}
+		return &key, nil
+	}
+	return p
+}
+
+// KeystoreProvider 从加密keystore文件读取凭证, 替换文件即可轮换密钥
+type KeystoreProvider struct {
+	fileCredentials
+}
+
+// NewKeystoreProvider 创建加密keystore凭证提供者
+// passphrase: 解锁keystore的口令
+func NewKeystoreProvider(path string, passphrase []byte) *KeystoreProvider {
+	p := &KeystoreProvider{}
+	p.path = path
+	p.parse = func(data []byte) (*KeyFile, error) {
+		return DecryptKeystore(data, passphrase)
+	}
+	return p
+}
+
+// keystore 加密keystore文件格式, 使用scrypt派生密钥, AES-256-GCM加密
+type keystore struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// scrypt默认参数
+const (
+	keystoreScryptN = 1 << 15
+	keystoreScryptR = 8
+	keystoreScryptP = 1
+)
+
+// 解密时允许的scrypt参数范围, 防止被篡改的文件降低强度或耗尽内存
+const (
+	keystoreMinScryptN = 1 << 15
+	keystoreMaxScryptN = 1 << 20
+	keystoreMaxScryptR = 32
+	keystoreMaxScryptP = 16
+	keystoreMinSalt    = 16
+	// scrypt需要128*N*r字节内存
+	keystoreMaxMemory = 1 << 30
+)
+
+// KeystoreDecryptError keystore口令错误或文件被篡改
+var KeystoreDecryptError = errors.New("keystore decrypt failed")
+
+// KeystoreParamsError keystore的scrypt参数超出允许范围
+var KeystoreParamsError = errors.New("keystore scrypt params out of range")
+
+// EncryptKeystore 使用口令加密凭证, 返回keystore文件内容
+func EncryptKeystore(key KeyFile, passphrase []byte) ([]byte, error) {
+	plaintext, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := keystore{
+		Version: 1,
+		KDF:     "scrypt",
+		N:       keystoreScryptN,
+		R:       keystoreScryptR,
+		P:       keystoreScryptP,
+		Salt:    make([]byte, 32),
+	}
+	if _, err := rand.Read(ks.Salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := keystoreCipher(&ks, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ks.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(ks.Nonce); err != nil {
+		return nil, err
+	}
+	ks.Ciphertext = aead.Seal(nil, ks.Nonce, plaintext, nil)
+
+	return json.MarshalIndent(ks, "", "  ")
+}
+
+// DecryptKeystore 使用口令解密keystore文件内容
+func DecryptKeystore(data []byte, passphrase []byte) (*KeyFile, error) {
+	var ks keystore
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, err
+	}
+	if ks.Version != 1 || ks.KDF != "scrypt" {
+		return nil, errors.New("unsupported keystore format")
+	}
+	if err := ks.checkParams(); err != nil {
+		return nil, err
+	}
+
+	aead, err := keystoreCipher(&ks, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(ks.Nonce) != aead.NonceSize() {
+		return nil, KeystoreDecryptError
+	}
+	plaintext, err := aead.Open(nil, ks.Nonce, ks.Ciphertext, nil)
+	if err != nil {
+		return nil, KeystoreDecryptError
+	}
+
+	var key KeyFile
+	if err := json.Unmarshal(plaintext, &key); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// checkParams 检查scrypt参数, 需在调用scrypt之前完成
+func (ks *keystore) checkParams() error {
+	n, r, p := ks.N, ks.R, ks.P
+	if n < keystoreMinScryptN || n > keystoreMaxScryptN || n&(n-1) != 0 {
+		return KeystoreParamsError
+	}
+	if r < 1 || r > keystoreMaxScryptR || p < 1 || p > keystoreMaxScryptP {
+		return KeystoreParamsError
+	}
+	if 128*int64(n)*int64(r) > keystoreMaxMemory || len(ks.Salt) < keystoreMinSalt {
+		return KeystoreParamsError
+	}
+	return nil
+}
+
+// keystoreCipher 根据口令派生AES-GCM
+func keystoreCipher(ks *keystore, passphrase []byte) (cipher.AEAD, error) {
+	derived, err := scrypt.Key(passphrase, ks.Salt, ks.N, ks.R, ks.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,207 @@
+package huobi_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/leek-box/sheep/huobi"
+)
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	key := huobi.KeyFile{AccessKey: "ak", SecretKey: "sk"}
+	data, err := huobi.EncryptKeystore(key, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := huobi.DecryptKeystore(data, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != key {
+		t.Fatalf("decrypted %+v, want %+v", *got, key)
+	}
+
+	if _, err := huobi.DecryptKeystore(data, []byte("wrong")); err != huobi.KeystoreDecryptError {
+		t.Fatalf("wrong passphrase: err = %v, want %v", err, huobi.KeystoreDecryptError)
+	}
+
+	tampered := editKeystore(t, data, func(ks map[string]interface{}) {
+		ciphertext := ks["ciphertext"].(string)
+		// 修改base64中间的一个字符, 保持编码合法
+		b := []byte(ciphertext)
+		if b[4] == 'A' {
+			b[4] = 'B'
+		} else {
+			b[4] = 'A'
+		}
+		ks["ciphertext"] = string(b)
+	})
+	if _, err := huobi.DecryptKeystore(tampered, []byte("passphrase")); err != huobi.KeystoreDecryptError {
+		t.Fatalf("tampered ciphertext: err = %v, want %v", err, huobi.KeystoreDecryptError)
+	}
+}
+
+func TestKeystoreParamsBounds(t *testing.T) {
+	data, err := huobi.EncryptKeystore(huobi.KeyFile{AccessKey: "ak", SecretKey: "sk"}, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		field string
+		value interface{}
+	}{
+		{"weak n", "n", 1 << 10},
+		{"n not power of two", "n", 1<<15 + 1},
+		{"huge n", "n", 1 << 30},
+		{"huge r", "r", 1 << 20},
+		{"zero p", "p", 0},
+		{"short salt", "salt", "c2FsdA=="},
+	}
+	for _, c := range cases {
+		edited := editKeystore(t, data, func(ks map[string]interface{}) {
+			ks[c.field] = c.value
+		})
+		start := time.Now()
+		if _, err := huobi.DecryptKeystore(edited, []byte("passphrase")); err != huobi.KeystoreParamsError {
+			t.Fatalf("%s: err = %v, want %v", c.name, err, huobi.KeystoreParamsError)
+		}
+		// 参数检查应在派生密钥之前完成
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("%s: rejected after %v", c.name, d)
+		}
+	}
+}
+
+// editKeystore 修改keystore文件中的字段
+func editKeystore(t *testing.T, data []byte, edit func(ks map[string]interface{})) []byte {
+	var ks map[string]interface{}
+	if err := json.Unmarshal(data, &ks); err != nil {
+		t.Fatal(err)
+	}
+	edit(ks)
+	edited, err := json.Marshal(ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return edited
+}
+
+// signWith 用提供者当前的凭证签名, 用于判断凭证是否变化
+func signWith(t *testing.T, p huobi.CredentialProvider) string {
+	t.Helper()
+	c, err := p.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := c.Signer.Sign("payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.AccessKey + ":" + signature
+}
+
+func TestFileProviderReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.json")
+	write := func(secret string) {
+		if err := ioutil.WriteFile(path, []byte(`{"access_key":"ak","secret_key":"`+secret+`"}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("secret1")
+
+	p := huobi.NewFileProvider(path)
+	first := signWith(t, p)
+	if first != "ak:"+mustSign(t, "secret1") {
+		t.Fatalf("unexpected credentials %s", first)
+	}
+
+	// 大小变化时重新加载
+	write("secret-two")
+	if got := signWith(t, p); got != "ak:"+mustSign(t, "secret-two") {
+		t.Fatalf("not reloaded after size change: %s", got)
+	}
+
+	// 大小不变, 只有修改时间变化时也重新加载
+	write("secret-2!!")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if got := signWith(t, p); got != "ak:"+mustSign(t, "secret-2!!") {
+		t.Fatalf("not reloaded after mtime change: %s", got)
+	}
+
+	// 文件被删除时返回错误
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Credentials(); err == nil {
+		t.Fatal("Credentials succeeded after the file was removed")
+	}
+}
+
+func TestKeystoreProviderReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	write := func(key huobi.KeyFile) {
+		data, err := huobi.EncryptKeystore(key, []byte("passphrase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(huobi.KeyFile{AccessKey: "ak1", SecretKey: "sk"})
+
+	p := huobi.NewKeystoreProvider(path, []byte("passphrase"))
+	if got := signWith(t, p); got != "ak1:"+mustSign(t, "sk") {
+		t.Fatalf("unexpected credentials %s", got)
+	}
+
+	write(huobi.KeyFile{AccessKey: "ak2-rotated", SecretKey: "sk"})
+	if got := signWith(t, p); got != "ak2-rotated:"+mustSign(t, "sk") {
+		t.Fatalf("not reloaded after rotation: %s", got)
+	}
+
+	if _, err := huobi.NewKeystoreProvider(path, []byte("wrong")).Credentials(); err != huobi.KeystoreDecryptError {
+		t.Fatalf("wrong passphrase: err = %v, want %v", err, huobi.KeystoreDecryptError)
+	}
+}
+
+func TestEnvProviderReload(t *testing.T) {
+	t.Setenv(huobi.EnvAccessKey, "ak1")
+	t.Setenv(huobi.EnvSecretKey, "sk1")
+	t.Setenv(huobi.EnvPrivateKey, "")
+
+	p := huobi.NewEnvProvider()
+	if got := signWith(t, p); got != "ak1:"+mustSign(t, "sk1") {
+		t.Fatalf("unexpected credentials %s", got)
+	}
+
+	os.Setenv(huobi.EnvAccessKey, "ak2")
+	os.Setenv(huobi.EnvSecretKey, "sk2")
+	if got := signWith(t, p); got != "ak2:"+mustSign(t, "sk2") {
+		t.Fatalf("environment change not picked up: %s", got)
+	}
+
+	os.Setenv(huobi.EnvAccessKey, "")
+	if _, err := p.Credentials(); err == nil {
+		t.Fatal("Credentials succeeded without an access key")
+	}
+}
+
+func mustSign(t *testing.T, secret string) string {
+	t.Helper()
+	signature, err := huobi.NewHmacSigner(secret).Sign("payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
@@ -0,0 +1,123 @@
+package huobi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// gzipReaderPool 复用gzip.Reader, 避免每条消息重新分配解压窗口
+var gzipReaderPool sync.Pool
+
+// bufferPool 复用解压后的消息缓冲区
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// gunzip 使用池化的reader和缓冲区解压, 用完后需调用releaseBuffer
+func gunzip(data []byte) (*bytes.Buffer, error) {
+	src := bytes.NewReader(data)
+
+	var r *gzip.Reader
+	if v := gzipReaderPool.Get(); v != nil {
+		r = v.(*gzip.Reader)
+		if err := r.Reset(src); err != nil {
+			gzipReaderPool.Put(r)
+			return nil, err
+		}
+	} else {
+		var err error
+		if r, err = gzip.NewReader(src); err != nil {
+			return nil, err
+		}
+	}
+	defer gzipReaderPool.Put(r)
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if _, err := io.Copy(buf, r); err != nil {
+		releaseBuffer(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// maxPooledBuffer 超过该大小的缓冲区不放回池中, 避免偶尔的大消息长期占用内存
+const maxPooledBuffer = 1 << 20
+
+func releaseBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// marketHeader 行情消息中用于路由的字段
+type marketHeader struct {
+	Ping     int64  `json:"ping"`
+	Pong     int64  `json:"pong"`
+	Ch       string `json:"ch"`
+	Subbed   string `json:"subbed"`
+	Unsubbed string `json:"unsubbed"`
+	Rep      string `json:"rep"`
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	ErrCode  string `json:"err-code"`
+	ErrMsg   string `json:"err-msg"`
+}
+
+var (
+	chPrefix   = []byte(`{"ch":"`)
+	pingPrefix = []byte(`{"ping":`)
+)
+
+// peekHeader 读取路由字段
+// 推送和ping以固定的字段开头, 直接截取而不解析整条消息; 其他消息都很小, 完整解析
+func peekHeader(msg []byte, h *marketHeader) error {
+	if bytes.HasPrefix(msg, chPrefix) {
+		rest := msg[len(chPrefix):]
+		if end := bytes.IndexByte(rest, '"'); end > 0 && bytes.IndexByte(rest[:end], '\\') < 0 {
+			h.Ch = string(rest[:end])
+			return nil
+		}
+	}
+
+	if bytes.HasPrefix(msg, pingPrefix) {
+		rest := msg[len(pingPrefix):]
+		end := 0
+		for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+			end++
+		}
+		if ping, err := strconv.ParseInt(string(rest[:end]), 10, 64); err == nil {
+			h.Ping = ping
+			return nil
+		}
+	}
+
+	return json.Unmarshal(msg, h)
+}
+
+// marketReply 订阅、取消订阅及请求的结果
+type marketReply struct {
+	header marketHeader
+	// 消息原文, 已从缓冲区复制
+	raw []byte
+}
+
+type replyChan = chan *marketReply
+
+// logBytes 仅在日志真正输出时才转换为字符串
+type logBytes []byte
+
+func (b logBytes) String() string {
+	return string(b)
+}
+
+func (b logBytes) MarshalText() ([]byte, error) {
+	return b, nil
+}
@@ -0,0 +1,95 @@
+package huobi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/bitly/go-simplejson"
+)
+
+// gzipDepth 一条压缩后的深度推送
+func gzipDepth(b *testing.B) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(`{"ch":"market.btcusdt.depth.step0","ts":1630000000000,"tick":{` +
+		`"bids":[[50000.1,0.5],[50000,1.2],[49999.5,0.3],[49999,2],[49998.7,0.8]],` +
+		`"asks":[[50000.2,0.4],[50000.5,1.1],[50001,0.6],[50001.3,3],[50002,0.9]],` +
+		`"version":100,"ts":1630000000000}}`))
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkDispatchDepth 对比一条深度推送从收到到解码为MarketDepth的开销
+// simplejson为原来的路径: 解压后解析为simplejson, 监听器再序列化并解码; raw为RawListener的路径
+func BenchmarkDispatchDepth(b *testing.B) {
+	frame := gzipDepth(b)
+
+	b.Run("simplejson", func(b *testing.B) {
+		listener := func(topic string, js *simplejson.Json) {
+			raw, err := js.MarshalJSON()
+			if err != nil {
+				b.Fatal(err)
+			}
+			var md MarketDepth
+			if err := json.Unmarshal(raw, &md); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r, err := gzip.NewReader(bytes.NewReader(frame))
+			if err != nil {
+				b.Fatal(err)
+			}
+			msg, err := ioutil.ReadAll(r)
+			if err != nil {
+				b.Fatal(err)
+			}
+			js, err := simplejson.NewJson(msg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ch, _ := js.Get("ch").String()
+			listener(ch, js)
+		}
+	})
+
+	b.Run("raw", func(b *testing.B) {
+		m := &Market{
+			listeners: make(map[string]map[*Subscription]RawListener),
+			mutex:     &sync.RWMutex{},
+			logger:    NopLogger,
+			metrics:   NopMetrics,
+		}
+		topic := "market.btcusdt.depth.step0"
+		m.listeners[topic] = map[*Subscription]RawListener{
+			newSubscription(m, topic): func(topic string, msg []byte) {
+				var md MarketDepth
+				if err := json.Unmarshal(msg, &md); err != nil {
+					b.Fatal(err)
+				}
+			},
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf, err := gunzip(frame)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var header marketHeader
+			if err := peekHeader(buf.Bytes(), &header); err != nil {
+				b.Fatal(err)
+			}
+			m.dispatch(header.Ch, buf.Bytes())
+			releaseBuffer(buf)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package huobi
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 行情Websocket的可选入口, /ws和/feed提供的主题不同, 不能互相切换
+const (
+	EndpointDefault = "wss://api.huobi.pro/ws"
+	// EndpointAWS 部署在AWS的入口, 服务器在AWS东京时延迟更低
+	EndpointAWS = "wss://api-aws.huobi.pro/ws"
+	// EndpointFeed 只提供MBP增量深度(market.$symbol.mbp.$levels)的入口
+	EndpointFeed = "wss://api.huobi.pro/feed"
+	// EndpointFeedAWS 部署在AWS的MBP增量深度入口
+	EndpointFeedAWS = "wss://api-aws.huobi.pro/feed"
+)
+
+// 资产和订单Websocket(v2)的可选入口
+const (
+	PrivateEndpointDefault = "wss://api.huobi.pro/ws/v2"
+	// PrivateEndpointAWS 部署在AWS的入口
+	PrivateEndpointAWS = "wss://api-aws.huobi.pro/ws/v2"
+)
+
+// DefaultEndpointFailover 连续连接失败多少次后切换到下一个入口
+const DefaultEndpointFailover = 3
+
+// defaultProbeTimeout 探测单个入口的超时时间
+const defaultProbeTimeout = 5 * time.Second
+
+// endpointSet 按顺序排列的入口, 当前入口连续连接失败达到上限后切换到下一个
+type endpointSet struct {
+	endpoints []string
+	current   int
+	failures  int
+	threshold int
+	mutex     sync.Mutex
+}
+
+func newEndpointSet(endpoints []string, threshold int) *endpointSet {
+	return &endpointSet{
+		endpoints: endpoints,
+		threshold: threshold,
+	}
+}
+
+// get 当前入口
+func (e *endpointSet) get() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.endpoints[e.current]
+}
+
+// succeed 连接成功, 清空失败次数
+func (e *endpointSet) succeed() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.failures = 0
+}
+
+// fail 记录一次连接失败, 达到上限时切换到下一个入口并返回新的入口
+func (e *endpointSet) fail() (next string, switched bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.failures++
+	if e.failures < e.threshold || len(e.endpoints) < 2 {
+		return e.endpoints[e.current], false
+	}
+	return e.next(), true
+}
+
+// skip 当前入口仍为endpoint时立即切换到下一个入口
+func (e *endpointSet) skip(endpoint string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.endpoints[e.current] == endpoint {
+		e.next()
+	}
+}
+
+// next 切换到下一个入口, 调用方需持有e.mutex
+func (e *endpointSet) next() string {
+	e.failures = 0
+	e.current = (e.current + 1) % len(e.endpoints)
+	return e.endpoints[e.current]
+}
+
+// endpointPath 入口的路径, 路径相同的入口提供相同的主题
+func endpointPath(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	return u.Path
+}
+
+// sameProtocol 只保留与第一个入口路径相同的入口
+// 切换到/feed后market.*.depth、K线等订阅都会失败, 因此故障切换只在同一种入口之间进行
+func sameProtocol(endpoints []string, logger Logger) []string {
+	if len(endpoints) == 0 {
+		return endpoints
+	}
+	path := endpointPath(endpoints[0])
+	kept := endpoints[:1:1]
+	for _, endpoint := range endpoints[1:] {
+		if endpointPath(endpoint) != path {
+			logger.Warn("endpoint ignored, protocol differs from the first endpoint", "endpoint", endpoint, "first", endpoints[0])
+			continue
+		}
+		kept = append(kept, endpoint)
+	}
+	return kept
+}
+
+// probeEndpoints 并发探测各入口建立连接的耗时, 按耗时从小到大排序
+// 无法连接的入口保持原有顺序排在最后
+func probeEndpoints(endpoints []string, dialer *websocket.Dialer, timeout time.Duration, logger Logger) []string {
+	rtts := make([]time.Duration, len(endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		i, endpoint := i, endpoint
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtts[i] = probeEndpoint(endpoint, dialer, timeout)
+			logger.Info("probe endpoint", "endpoint", endpoint, "rtt", rtts[i])
+		}()
+	}
+	wg.Wait()
+
+	index := make([]int, len(endpoints))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(a, b int) bool {
+		ra, rb := rtts[index[a]], rtts[index[b]]
+		if ra < 0 || rb < 0 {
+			return rb < 0 && ra >= 0
+		}
+		return ra < rb
+	})
+
+	sorted := make([]string, len(endpoints))
+	for i, j := range index {
+		sorted[i] = endpoints[j]
+	}
+	return sorted
+}
+
+// probeEndpoint 建立一次连接并返回耗时, 无法连接时返回-1
+func probeEndpoint(endpoint string, dialer *websocket.Dialer, timeout time.Duration) time.Duration {
+	d := *dialer
+	d.HandshakeTimeout = timeout
+
+	start := time.Now()
+	ws, _, err := d.Dial(endpoint, nil)
+	if err != nil {
+		return -1
+	}
+	rtt := time.Since(start)
+	ws.Close()
+	return rtt
+}
@@ -0,0 +1,25 @@
+package huobi
+
+import "testing"
+
+func TestSameProtocol(t *testing.T) {
+	got := sameProtocol([]string{EndpointDefault, EndpointFeed, EndpointAWS, EndpointFeedAWS}, NopLogger)
+	if len(got) != 2 || got[0] != EndpointDefault || got[1] != EndpointAWS {
+		t.Fatalf("ws endpoints kept %v", got)
+	}
+
+	got = sameProtocol([]string{EndpointFeedAWS, EndpointDefault, EndpointFeed}, NopLogger)
+	if len(got) != 2 || got[0] != EndpointFeedAWS || got[1] != EndpointFeed {
+		t.Fatalf("feed endpoints kept %v", got)
+	}
+
+	// 故障切换只在同一种入口之间轮换
+	o := newOptions([]Option{WithEndpoints(EndpointDefault, EndpointFeed, EndpointAWS), WithEndpointFailover(1)})
+	e := newEndpointSet(o.endpoints, o.endpointFailover)
+	for i := 0; i < 4; i++ {
+		next, switched := e.fail()
+		if !switched || endpointPath(next) != "/ws" {
+			t.Fatalf("failover %d switched to %s", i, next)
+		}
+	}
+}
@@ -0,0 +1,751 @@
+package huobi
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 下单方向
+const (
+	SideBuy  = "buy"
+	SideSell = "sell"
+)
+
+// AlgoType 执行算法
+type AlgoType string
+
+const (
+	// AlgoTWAP 在执行时长内按时间均匀拆单
+	AlgoTWAP AlgoType = "twap"
+	// AlgoVWAP 按市场成交量的分布拆单
+	AlgoVWAP AlgoType = "vwap"
+	// AlgoIceberg 冰山单, 盘口中只显示一部分数量
+	AlgoIceberg AlgoType = "iceberg"
+)
+
+// ExecutionState 算法单状态
+type ExecutionState string
+
+const (
+	ExecutionRunning   ExecutionState = "running"
+	ExecutionPaused    ExecutionState = "paused"
+	ExecutionCompleted ExecutionState = "completed" // 全部成交
+	ExecutionExpired   ExecutionState = "expired"   // 执行时长结束时仍未全部成交
+	ExecutionCanceled  ExecutionState = "canceled"
+	ExecutionFailed    ExecutionState = "failed"
+)
+
+// Final 是否为终结状态
+func (s ExecutionState) Final() bool {
+	switch s {
+	case ExecutionCompleted, ExecutionExpired, ExecutionCanceled, ExecutionFailed:
+		return true
+	}
+	return false
+}
+
+// 算法单的默认配置
+const (
+	defaultSliceInterval = 30 * time.Second
+	// 撤单后超过该时间仍未收到终结状态时主动查询
+	defaultCancelTimeout = 10 * time.Second
+	// 连续下单失败的次数达到该值时终止
+	maxPlaceErrors = 3
+)
+
+// ExecutionConfig 算法单配置
+type ExecutionConfig struct {
+	Symbol string
+	Side   string  // SideBuy或SideSell
+	Amount float64 // 总数量
+
+	// LimitPrice 买入的最高价或卖出的最低价, 0表示不限
+	// 市场价超出限价时子单以限价挂单; 冰山单必须设置, 所有子单都以该价格委托
+	LimitPrice float64
+
+	// Duration 执行时长, TWAP和VWAP必须设置, 冰山单为0时直到全部成交
+	Duration time.Duration
+	// Interval TWAP和VWAP的拆单间隔, 默认30秒
+	Interval time.Duration
+
+	// DisplayAmount 冰山单每次显示的数量
+	DisplayAmount float64
+
+	// 交易对的数量和价格精度, 如0.0001, 下单前按其截断, 为0时不截断
+	AmountStep float64
+	PriceTick  float64
+	// MinAmount 子单的最小数量, 不足时不下单
+	MinAmount float64
+}
+
+// validate 检查配置并填充默认值
+func (c *ExecutionConfig) validate(algo AlgoType) error {
+	if c.Symbol == "" {
+		return errors.New("execution symbol is empty")
+	}
+	if c.Side != SideBuy && c.Side != SideSell {
+		return errors.New("execution side must be buy or sell")
+	}
+	if c.Amount <= 0 {
+		return errors.New("execution amount must be positive")
+	}
+	if c.LimitPrice < 0 || c.Duration < 0 || c.Interval < 0 {
+		return errors.New("execution price and durations must not be negative")
+	}
+
+	if algo == AlgoIceberg {
+		if c.LimitPrice == 0 {
+			return errors.New("iceberg requires a limit price")
+		}
+		if c.DisplayAmount <= 0 {
+			return errors.New("iceberg display amount must be positive")
+		}
+		return nil
+	}
+
+	if c.Duration == 0 {
+		return errors.New("execution duration must be positive")
+	}
+	if c.Interval == 0 {
+		c.Interval = defaultSliceInterval
+	}
+	if c.Interval > c.Duration {
+		c.Interval = c.Duration
+	}
+	return nil
+}
+
+// ExecutionReport 算法单的进度, 结束后即为最终的成交汇总
+type ExecutionReport struct {
+	Algo   AlgoType
+	Symbol string
+	Side   string
+	State  ExecutionState
+
+	Amount   float64 // 总数量
+	Filled   float64 // 累计成交数量
+	AvgPrice float64 // 成交均价
+
+	// 所有子单的ID, 按下单顺序排列
+	ChildOrders []string
+	// 当前未终结的子单, 没有时为空
+	Working string
+
+	Started  time.Time
+	Finished time.Time // 结束前为零值
+
+	// 执行期间市场的成交均价, 仅VWAP
+	MarketVWAP float64
+
+	// 失败的原因, 或ctx结束时的ctx.Err()
+	Err error
+}
+
+// Remaining 未成交的数量
+func (r *ExecutionReport) Remaining() float64 {
+	return math.Max(r.Amount-r.Filled, 0)
+}
+
+// ExecutionListener 算法单进度监听器, 在有成交、下单及状态变化时调用
+type ExecutionListener = func(report *ExecutionReport)
+
+// childOrder 当前的子单
+type childOrder struct {
+	id     string
+	amount float64
+	// 已计入进度的成交数量
+	filled float64
+	// 已申请撤单
+	canceling  bool
+	canceledAt time.Time
+}
+
+// Execution 正在执行的算法单
+// 子单的成交来自OrderManager的事件, 需要同时运行h.Orders().Run
+type Execution struct {
+	h      *Huobi
+	algo   AlgoType
+	config ExecutionConfig
+
+	report    ExecutionReport
+	notional  float64 // 累计成交额
+	listeners []ExecutionListener
+
+	// 控制请求
+	paused   bool
+	canceled bool
+
+	// 待处理的订单事件
+	events []*OrderEvent
+	wake   chan struct{}
+	done   chan struct{}
+
+	// 行情, 在websocket读协程中写入
+	bbo            *BBO
+	intervalVolume float64 // 上次拆单后的市场成交量
+	marketVolume   float64
+	marketNotional float64
+
+	mutex sync.Mutex
+
+	// 以下字段只在run协程中访问
+	working     *childOrder
+	target      float64 // 到目前为止计划成交的数量
+	step        int     // 已经过的拆单次数
+	placeErrors int
+	ending      ExecutionState
+	endErr      error
+
+	logger Logger
+}
+
+// TWAP 在config.Duration内每config.Interval下一个子单, 使累计成交跟上按时间均分的计划
+// 子单以对手价委托, 受LimitPrice限制; ctx结束时撤销未成交的子单并终止
+func (h *Huobi) TWAP(ctx context.Context, config ExecutionConfig) (*Execution, error) {
+	return h.execute(ctx, AlgoTWAP, config)
+}
+
+// VWAP 与TWAP相同的方式拆单, 但每个子单的数量按成交明细中上一个间隔的市场成交量占预计剩余成交量的比例分配
+// 成交活跃时多下, 清淡时少下, 执行时长结束前分配完全部数量
+func (h *Huobi) VWAP(ctx context.Context, config ExecutionConfig) (*Execution, error) {
+	return h.execute(ctx, AlgoVWAP, config)
+}
+
+// Iceberg 以config.LimitPrice挂单, 每次只显示config.DisplayAmount, 成交后再挂下一部分
+func (h *Huobi) Iceberg(ctx context.Context, config ExecutionConfig) (*Execution, error) {
+	return h.execute(ctx, AlgoIceberg, config)
+}
+
+func (h *Huobi) execute(ctx context.Context, algo AlgoType, config ExecutionConfig) (*Execution, error) {
+	if err := config.validate(algo); err != nil {
+		return nil, err
+	}
+
+	e := &Execution{
+		h:      h,
+		algo:   algo,
+		config: config,
+		report: ExecutionReport{
+			Algo:    algo,
+			Symbol:  config.Symbol,
+			Side:    config.Side,
+			State:   ExecutionRunning,
+			Amount:  config.Amount,
+			Started: time.Now(),
+		},
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		logger: h.logger,
+	}
+
+	var subs []*Subscription
+	unsubscribe := func() {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
+	}
+	if algo != AlgoIceberg {
+		sub, err := h.SubscribeBBO(config.Symbol, e.setBBO)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	if algo == AlgoVWAP {
+		sub, err := h.SubscribeDetailFunc(config.Symbol, e.addTrades)
+		if err != nil {
+			unsubscribe()
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	// 事件流不随ctx结束, ctx结束后还需要等待子单撤销
+	// 丢失子单的终结事件会让算法单一直等待, 因此不丢弃事件; pumpEvents只转存, 不会拖慢OrderManager的分发
+	eventsCtx, stopEvents := context.WithCancel(context.Background())
+	go e.pumpEvents(h.orders.Events(eventsCtx, StreamConfig{Policy: OverflowBlock}))
+	go e.run(ctx, func() {
+		stopEvents()
+		unsubscribe()
+	})
+
+	return e, nil
+}
+
+// OnProgress 添加进度监听器
+func (e *Execution) OnProgress(listener ExecutionListener) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.listeners = append(e.listeners, listener)
+}
+
+// Progress 当前进度
+func (e *Execution) Progress() *ExecutionReport {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.snapshot()
+}
+
+// Pause 撤销当前子单并暂停下单, 恢复后TWAP和VWAP会追赶暂停期间的计划数量
+func (e *Execution) Pause() {
+	e.mutex.Lock()
+	e.paused = true
+	e.mutex.Unlock()
+	e.signal()
+}
+
+// Resume 恢复下单
+func (e *Execution) Resume() {
+	e.mutex.Lock()
+	e.paused = false
+	e.mutex.Unlock()
+	e.signal()
+}
+
+// Cancel 撤销当前子单并终止, 可通过Wait等待撤单完成
+func (e *Execution) Cancel() {
+	e.mutex.Lock()
+	e.canceled = true
+	e.mutex.Unlock()
+	e.signal()
+}
+
+// Done 结束后关闭
+func (e *Execution) Done() <-chan struct{} {
+	return e.done
+}
+
+// Wait 等待结束并返回成交汇总, 执行失败时同时返回失败的原因
+func (e *Execution) Wait(ctx context.Context) (*ExecutionReport, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-e.done:
+	}
+	report := e.Progress()
+	if report.State == ExecutionFailed {
+		return report, report.Err
+	}
+	return report, nil
+}
+
+// snapshot 复制当前进度, 调用方需持有e.mutex
+func (e *Execution) snapshot() *ExecutionReport {
+	report := e.report
+	report.ChildOrders = append([]string(nil), e.report.ChildOrders...)
+	return &report
+}
+
+// updateReport 修改进度并通知监听器
+func (e *Execution) updateReport(fn func(r *ExecutionReport)) {
+	e.mutex.Lock()
+	fn(&e.report)
+	report := e.snapshot()
+	listeners := append([]ExecutionListener(nil), e.listeners...)
+	e.mutex.Unlock()
+
+	for _, listener := range listeners {
+		listener(report)
+	}
+}
+
+func (e *Execution) signal() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Execution) setBBO(symbol string, bbo *BBO) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.bbo = bbo
+}
+
+func (e *Execution) addTrades(symbol string, detail *MarketTradeDetail) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, trade := range detail.Tick.Data {
+		e.intervalVolume += trade.Amount
+		e.marketVolume += trade.Amount
+		e.marketNotional += trade.Amount * trade.Price
+	}
+}
+
+// pumpEvents 将订单事件转存到队列中, 由run协程处理
+func (e *Execution) pumpEvents(events <-chan *OrderEvent) {
+	for event := range events {
+		if event.Order.Symbol != e.config.Symbol {
+			continue
+		}
+		e.mutex.Lock()
+		e.events = append(e.events, event)
+		e.mutex.Unlock()
+		e.signal()
+	}
+}
+
+// run 执行算法单直到结束
+func (e *Execution) run(ctx context.Context, stop func()) {
+	defer stop()
+
+	var slices <-chan time.Time
+	if e.algo != AlgoIceberg {
+		ticker := time.NewTicker(e.config.Interval)
+		defer ticker.Stop()
+		slices = ticker.C
+	}
+	var deadline <-chan time.Time
+	if e.config.Duration > 0 {
+		timer := time.NewTimer(e.config.Duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	check := time.NewTicker(time.Second)
+	defer check.Stop()
+
+	ctxDone := ctx.Done()
+	e.slice()
+	for !e.finished() {
+		select {
+		case <-ctxDone:
+			ctxDone = nil
+			e.stop(ExecutionCanceled, ctx.Err())
+		case <-e.wake:
+			e.handleEvents()
+			e.control()
+		case <-slices:
+			e.slice()
+		case <-deadline:
+			e.stop(ExecutionExpired, nil)
+		case <-check.C:
+			e.checkWorking()
+		}
+	}
+}
+
+func (e *Execution) finished() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.report.State.Final()
+}
+
+// control 处理暂停、恢复及取消
+func (e *Execution) control() {
+	e.mutex.Lock()
+	paused, canceled, state := e.paused, e.canceled, e.report.State
+	e.mutex.Unlock()
+
+	switch {
+	case canceled:
+		e.stop(ExecutionCanceled, nil)
+	case e.ending != "":
+	case paused && state == ExecutionRunning:
+		e.updateReport(func(r *ExecutionReport) {
+			r.State = ExecutionPaused
+		})
+		e.cancelChild()
+	case !paused && state == ExecutionPaused:
+		e.updateReport(func(r *ExecutionReport) {
+			r.State = ExecutionRunning
+		})
+		e.place()
+	}
+}
+
+// slice 到达拆单时间, 推进计划数量并撤销上一个子单, 撤单完成后再下单
+func (e *Execution) slice() {
+	if e.ending != "" {
+		return
+	}
+	e.step++
+	e.target = e.nextTarget()
+	if e.working != nil {
+		e.cancelChild()
+		return
+	}
+	e.place()
+}
+
+// nextTarget 到下一次拆单时计划成交的数量
+func (e *Execution) nextTarget() float64 {
+	amount := e.config.Amount
+	switch e.algo {
+	case AlgoTWAP:
+		slices := int(math.Ceil(float64(e.config.Duration) / float64(e.config.Interval)))
+		if e.step >= slices {
+			return amount
+		}
+		return amount * float64(e.step) / float64(slices)
+
+	case AlgoVWAP:
+		e.mutex.Lock()
+		volume, total := e.intervalVolume, e.marketVolume
+		e.intervalVolume = 0
+		e.mutex.Unlock()
+
+		now := time.Now()
+		end := e.report.Started.Add(e.config.Duration)
+		after := end.Sub(now) - e.config.Interval
+		if after <= 0 {
+			return amount
+		}
+
+		// 还没有成交数据时按时间均分
+		share := float64(e.config.Interval) / float64(end.Sub(now))
+		if total > 0 {
+			rate := total / now.Sub(e.report.Started).Seconds()
+			share = volume / (volume + rate*after.Seconds())
+		}
+		return e.target + (amount-e.target)*share
+	}
+	return amount
+}
+
+// price 子单的委托价
+func (e *Execution) price() (float64, bool) {
+	limit := e.config.LimitPrice
+	if e.algo == AlgoIceberg {
+		return roundStep(limit, e.config.PriceTick, e.config.Side == SideSell), true
+	}
+
+	e.mutex.Lock()
+	bbo := e.bbo
+	e.mutex.Unlock()
+	if bbo == nil {
+		return 0, false
+	}
+
+	if e.config.Side == SideBuy {
+		price := bbo.Ask
+		if limit > 0 && (price == 0 || price > limit) {
+			price = limit
+		}
+		return roundStep(price, e.config.PriceTick, false), price > 0
+	}
+	price := bbo.Bid
+	if limit > 0 && price < limit {
+		price = limit
+	}
+	return roundStep(price, e.config.PriceTick, true), price > 0
+}
+
+// place 没有子单时按计划数量与已成交数量之差下单
+func (e *Execution) place() {
+	if e.working != nil || e.ending != "" {
+		return
+	}
+	e.mutex.Lock()
+	paused, filled := e.paused, e.report.Filled
+	e.mutex.Unlock()
+	if paused {
+		return
+	}
+
+	amount := e.target - filled
+	if e.algo == AlgoIceberg {
+		amount = math.Min(amount, e.config.DisplayAmount)
+	}
+	amount = roundStep(amount, e.config.AmountStep, false)
+	if amount <= 0 || amount < e.config.MinAmount {
+		return
+	}
+	price, ok := e.price()
+	if !ok {
+		e.logger.Debug("execution waits for quote", "symbol", e.config.Symbol)
+		return
+	}
+
+	id, err := e.h.Place(amount, price, e.config.Symbol, e.config.Side+"-limit")
+	if err != nil {
+		e.placeErrors++
+		e.logger.Warn("execution place failed", "symbol", e.config.Symbol, "algo", e.algo, "err", err)
+		if e.placeErrors >= maxPlaceErrors {
+			e.stop(ExecutionFailed, err)
+		}
+		return
+	}
+	e.placeErrors = 0
+	e.working = &childOrder{id: id, amount: amount}
+	e.updateReport(func(r *ExecutionReport) {
+		r.ChildOrders = append(r.ChildOrders, id)
+		r.Working = id
+	})
+}
+
+// cancelChild 撤销当前子单, 收到终结状态后才视为撤销完成
+func (e *Execution) cancelChild() {
+	if e.working == nil || e.working.canceling {
+		return
+	}
+	e.working.canceling = true
+	e.working.canceledAt = time.Now()
+	if err := e.h.SubmitCancel(e.working.id); err != nil {
+		// 可能已经成交, 以订单事件为准
+		e.logger.Debug("execution cancel failed", "order-id", e.working.id, "err", err)
+	}
+}
+
+// checkWorking 撤单长时间没有结果时主动查询并重新撤单, 没有子单时重试下单
+func (e *Execution) checkWorking() {
+	if e.working == nil {
+		e.place()
+		return
+	}
+	if !e.working.canceling || time.Since(e.working.canceledAt) < defaultCancelTimeout {
+		return
+	}
+	if err := e.h.orders.refresh(e.working.id); err != nil {
+		e.logger.Warn("execution refresh order failed", "order-id", e.working.id, "err", err)
+	}
+	// OrderManager中已终结的订单不会再产生事件, 直接以其记录为准
+	if order, ok := e.h.orders.Order(e.working.id); ok && order.State.Final() {
+		e.logger.Warn("execution child order final without event", "order-id", order.ID, "state", string(order.State))
+		if order.Filled > e.working.filled {
+			e.childFilled(order.Filled-e.working.filled, order.Price)
+		}
+		e.childFinal(order.State, nil)
+		return
+	}
+	// 仍未终结时再撤一次
+	e.working.canceling = false
+	e.cancelChild()
+}
+
+// handleEvents 处理当前子单的成交及终结
+func (e *Execution) handleEvents() {
+	e.mutex.Lock()
+	events := e.events
+	e.events = nil
+	e.mutex.Unlock()
+
+	for _, event := range events {
+		if e.working == nil || event.Order.ID != e.working.id {
+			continue
+		}
+
+		if event.Type == OrderEventFill {
+			e.childFilled(event.FillVolume, event.FillPrice)
+		}
+		if !event.Order.State.Final() {
+			continue
+		}
+		if e.childFinal(event.Order.State, event.Err) {
+			return
+		}
+	}
+}
+
+// childFilled 将当前子单的成交计入进度
+func (e *Execution) childFilled(volume, price float64) {
+	e.working.filled += volume
+	e.notional += volume * price
+	e.updateReport(func(r *ExecutionReport) {
+		r.Filled += volume
+		r.AvgPrice = e.notional / r.Filled
+	})
+}
+
+// childFinal 当前子单已终结, 继续下单或结束算法单, 结束时返回true
+func (e *Execution) childFinal(state OrderState, err error) bool {
+	id, canceling := e.working.id, e.working.canceling
+	e.working = nil
+	e.updateReport(func(r *ExecutionReport) {
+		r.Working = ""
+	})
+	switch state {
+	case OrderCanceled, OrderPartialCanceled:
+		if !canceling {
+			e.logger.Warn("execution child order canceled externally", "order-id", id)
+		}
+	case OrderRejected:
+		e.logger.Warn("execution child order rejected", "order-id", id, "err", err)
+	}
+
+	if e.ending != "" || e.filledAll() {
+		e.finish()
+		return true
+	}
+	e.place()
+	return false
+}
+
+// filledAll 剩余数量已不足以再下单
+func (e *Execution) filledAll() bool {
+	e.mutex.Lock()
+	remaining := e.report.Remaining()
+	e.mutex.Unlock()
+
+	if remaining <= e.config.Amount*1e-9 {
+		return true
+	}
+	remaining = roundStep(remaining, e.config.AmountStep, false)
+	return remaining <= 0 || remaining < e.config.MinAmount
+}
+
+// stop 以state结束, 有子单时先撤单
+func (e *Execution) stop(state ExecutionState, err error) {
+	if e.ending != "" || e.finished() {
+		return
+	}
+	e.ending, e.endErr = state, err
+	if e.working != nil {
+		e.cancelChild()
+		return
+	}
+	e.finish()
+}
+
+// finish 记录最终状态, 全部成交时总是视为完成
+func (e *Execution) finish() {
+	state, err := e.ending, e.endErr
+	if state == "" || e.filledAll() {
+		state, err = ExecutionCompleted, nil
+	}
+
+	e.mutex.Lock()
+	vwap := 0.0
+	if e.algo == AlgoVWAP && e.marketVolume > 0 {
+		vwap = e.marketNotional / e.marketVolume
+	}
+	e.mutex.Unlock()
+
+	e.updateReport(func(r *ExecutionReport) {
+		r.State = state
+		r.Err = err
+		r.Finished = time.Now()
+		r.MarketVWAP = vwap
+	})
+	close(e.done)
+
+	report := e.Progress()
+	e.logger.Info("execution finished", "symbol", report.Symbol, "algo", report.Algo, "state", report.State,
+		"filled", report.Filled, "avg-price", report.AvgPrice, "orders", len(report.ChildOrders))
+}
+
+// roundStep 将v按step截断, up为true时向上取整, step为0时不处理
+func roundStep(v, step float64, up bool) float64 {
+	if step <= 0 {
+		return v
+	}
+	n := v / step
+	if up {
+		n = math.Ceil(n - 1e-9)
+	} else {
+		n = math.Floor(n + 1e-9)
+	}
+
+	// 按step的小数位数格式化, 去掉浮点误差
+	decimals := 0
+	s := strconv.FormatFloat(step, 'f', -1, 64)
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		decimals = len(s) - i - 1
+	}
+	r, _ := strconv.ParseFloat(strconv.FormatFloat(n*step, 'f', decimals, 64), 64)
+	return r
+}
@@ -0,0 +1,49 @@
+package huobi
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingProvider 总是返回错误, REST请求立即失败
+type failingProvider struct{}
+
+func (failingProvider) Credentials() (*Credentials, error) {
+	return nil, errors.New("no credentials")
+}
+
+// 子单的终结事件丢失后, 撤单超时时以OrderManager中的记录结束子单
+func TestExecutionMissedFinalEvent(t *testing.T) {
+	h := &Huobi{credentials: failingProvider{}, logger: NopLogger, metrics: NopMetrics}
+	h.orders = newOrderManager(h)
+	h.orders.track("7", "btcusdt", "buy-limit", 10, 2)
+
+	e := &Execution{
+		h:      h,
+		algo:   AlgoIceberg,
+		config: ExecutionConfig{Symbol: "btcusdt", Side: SideBuy, Amount: 2, LimitPrice: 10, DisplayAmount: 2},
+		report: ExecutionReport{Algo: AlgoIceberg, State: ExecutionRunning, Amount: 2, ChildOrders: []string{"7"}, Working: "7"},
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		logger: NopLogger,
+	}
+	e.working = &childOrder{id: "7", amount: 2}
+	e.childFilled(0.5, 10)
+
+	// 订单在算法单收到事件前终结, 事件没有送达
+	h.orders.update("7", orderUpdate{state: OrderFilled, filled: 2})
+	e.stop(ExecutionCanceled, nil)
+	e.working.canceledAt = time.Now().Add(-defaultCancelTimeout)
+	e.checkWorking()
+
+	select {
+	case <-e.Done():
+	default:
+		t.Fatal("execution still waiting for the child order")
+	}
+	r := e.Progress()
+	if r.State != ExecutionCompleted || r.Filled != 2 || r.AvgPrice != 10 || r.Working != "" {
+		t.Fatalf("report %+v", r)
+	}
+}
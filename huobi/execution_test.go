@@ -0,0 +1,191 @@
+package huobi_test
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/leek-box/sheep/huobi"
+	"github.com/leek-box/sheep/huobi/huobitest"
+)
+
+// newExecutionHuobi 创建连接假REST和行情服务器的Huobi, 订单状态每10ms轮询一次
+func newExecutionHuobi(t *testing.T) (*huobi.Huobi, *huobitest.Server, *huobitest.RestServer) {
+	ws := huobitest.NewServer()
+	t.Cleanup(ws.Close)
+	rest := huobitest.NewRestServer()
+	t.Cleanup(rest.Close)
+	rest.AddAccount("ak", "sk")
+
+	h, err := huobi.NewHuobi("ak", "sk", huobi.WithRestHost(rest.URL), huobi.WithEndpoints(ws.URL),
+		huobi.WithPrivateEndpoint("ws://127.0.0.1:1/ws/v2"), huobi.WithProxy(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Orders().PollInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go h.Orders().Run(ctx)
+	return h, ws, rest
+}
+
+// fillOpen 将所有未终结订单剩余数量的frac成交
+func fillOpen(rest *huobitest.RestServer, frac float64) {
+	for _, o := range rest.Orders() {
+		if o.State == "submitted" || o.State == "partial-filled" {
+			amount, _ := strconv.ParseFloat(o.Amount, 64)
+			filled, _ := strconv.ParseFloat(o.FieldAmount, 64)
+			rest.FillOrder(o.ID, (amount-filled)*frac)
+		}
+	}
+}
+
+// keepFilling 每隔interval成交一次, 直到算法单结束
+func keepFilling(e *huobi.Execution, rest *huobitest.RestServer, frac float64, interval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-e.Done():
+				return
+			case <-time.After(interval):
+				fillOpen(rest, frac)
+			}
+		}
+	}()
+}
+
+func wait(t *testing.T, e *huobi.Execution) *huobi.ExecutionReport {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r, err := e.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func parse(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func TestIcebergSlices(t *testing.T) {
+	h, _, rest := newExecutionHuobi(t)
+	e, err := h.Iceberg(context.Background(), huobi.ExecutionConfig{
+		Symbol: "btcusdt", Side: huobi.SideBuy, Amount: 3, LimitPrice: 10, DisplayAmount: 1.3, AmountStep: 0.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keepFilling(e, rest, 1, 20*time.Millisecond)
+
+	r := wait(t, e)
+	if r.State != huobi.ExecutionCompleted || r.Filled != 3 || r.AvgPrice != 10 {
+		t.Fatalf("report %+v", r)
+	}
+	// 每次只显示1.3, 最后一次为剩余的0.4
+	want := []string{"1.3", "1.3", "0.4"}
+	orders := rest.Orders()
+	if len(orders) != len(want) || len(r.ChildOrders) != len(want) {
+		t.Fatalf("%d orders on the server, %d in the report, want %d", len(orders), len(r.ChildOrders), len(want))
+	}
+	for i, o := range orders {
+		if o.Amount != want[i] || o.Price != "10" || o.State != "filled" {
+			t.Fatalf("order %d: %+v", i, o)
+		}
+	}
+}
+
+// 每个子单只成交一半, 下次拆单时撤销并以新的计划数量与已成交之差重新下单
+func TestTWAPCancelReplace(t *testing.T) {
+	h, ws, rest := newExecutionHuobi(t)
+	quotes := make(chan struct{})
+	defer close(quotes)
+	go func() {
+		for {
+			select {
+			case <-quotes:
+				return
+			case <-time.After(10 * time.Millisecond):
+				ws.Push("market.btcusdt.bbo", map[string]interface{}{"symbol": "btcusdt", "ask": 11, "bid": 10})
+			}
+		}
+	}()
+
+	// 5次拆单, 每次计划0.8
+	e, err := h.TWAP(context.Background(), huobi.ExecutionConfig{
+		Symbol: "btcusdt", Side: huobi.SideBuy, Amount: 4, LimitPrice: 10.5,
+		Duration: 650 * time.Millisecond, Interval: 150 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keepFilling(e, rest, 0.5, 20*time.Millisecond)
+
+	r := wait(t, e)
+	if r.State != huobi.ExecutionExpired {
+		t.Fatalf("state %s, want %s", r.State, huobi.ExecutionExpired)
+	}
+	orders := rest.Orders()
+	if len(orders) < 2 || len(orders) != len(r.ChildOrders) {
+		t.Fatalf("%d orders on the server, %d in the report", len(orders), len(r.ChildOrders))
+	}
+
+	var filled, lastTarget float64
+	for i, o := range orders {
+		if o.Price != "10.5" {
+			t.Fatalf("order %d priced %s above the limit", i, o.Price)
+		}
+		// 执行结束时所有子单都已撤销
+		if o.State != "partial-canceled" && o.State != "canceled" {
+			t.Fatalf("order %d left in state %s", i, o.State)
+		}
+		target := filled + parse(o.Amount)
+		if steps := target / 0.8; math.Abs(steps-math.Round(steps)) > 1e-6 || target <= lastTarget {
+			t.Fatalf("order %d: amount %s after %v filled does not catch up with the plan", i, o.Amount, filled)
+		}
+		lastTarget = target
+		filled += parse(o.FieldAmount)
+	}
+	if math.Abs(r.Filled-filled) > 1e-9 {
+		t.Fatalf("report filled %v, orders filled %v", r.Filled, filled)
+	}
+	if len(h.Orders().OpenOrders("")) != 0 {
+		t.Fatal("open orders left after the execution ended")
+	}
+}
+
+func TestExecutionCancel(t *testing.T) {
+	h, _, rest := newExecutionHuobi(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	e, err := h.Iceberg(ctx, huobi.ExecutionConfig{Symbol: "btcusdt", Side: huobi.SideSell, Amount: 3, LimitPrice: 10, DisplayAmount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); e.Progress().Working == ""; {
+		if time.Now().After(deadline) {
+			t.Fatal("no child order placed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fillOpen(rest, 0.5)
+	for deadline := time.Now().Add(5 * time.Second); e.Progress().Filled == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("fill not reported")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// ctx结束后撤销子单, 撤单完成后才结束
+	cancel()
+	r := wait(t, e)
+	if r.State != huobi.ExecutionCanceled || r.Err != context.Canceled || r.Filled != 0.5 || r.Working != "" {
+		t.Fatalf("report %+v", r)
+	}
+	if o := rest.Orders(); len(o) != 1 || o[0].State != "partial-canceled" {
+		t.Fatalf("orders %+v", o)
+	}
+}
@@ -0,0 +1,192 @@
+package huobi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// KlineBatchSize 单次请求最多返回的K线数量
+const KlineBatchSize = 300
+
+// DefaultBackfillInterval 两次历史数据请求之间的默认最小间隔, 避免触发请求频率限制
+const DefaultBackfillInterval = 200 * time.Millisecond
+
+// backfillRetries 回补时触发频率限制后的最多重试次数, 每次重试的等待时间加倍
+const backfillRetries = 5
+
+// minDuration 周期的最短时长, 用于切分请求窗口, 保证每个窗口内的K线不超过KlineBatchSize
+// 时长不固定的周期取最短值, 如1mon取28天, 窗口内的K线数只会更少
+func (p Period) minDuration() time.Duration {
+	switch p {
+	case Period1Min:
+		return time.Minute
+	case Period5Min:
+		return 5 * time.Minute
+	case Period15Min:
+		return 15 * time.Minute
+	case Period30Min:
+		return 30 * time.Minute
+	case Period60Min:
+		return time.Hour
+	case Period4Hour:
+		return 4 * time.Hour
+	case Period1Day:
+		return 24 * time.Hour
+	case Period1Week:
+		return 7 * 24 * time.Hour
+	case Period1Mon:
+		return 28 * 24 * time.Hour
+	case Period1Year:
+		return 365 * 24 * time.Hour
+	}
+	return 0
+}
+
+type marketKlineRep struct {
+	Rep    string  `json:"rep"`
+	Status string  `json:"status"`
+	Data   []Kline `json:"data"`
+}
+
+// KlineBatchListener 历史K线监听器, 每批K线按ID升序且不重复, 返回错误则停止回补
+type KlineBatchListener = func(symbol string, period Period, klines []Kline) error
+
+// BackfillKline 回补[from, to]时间范围内的历史K线
+// 长时间范围会被切分为多次请求, 请求之间的间隔由WithBackfillInterval设置, 结果按时间顺序依次交给listener
+func (h *Huobi) BackfillKline(ctx context.Context, symbol string, period Period, from, to time.Time, listener KlineBatchListener) error {
+	if !period.Valid() {
+		return fmt.Errorf("invalid kline period %q", period)
+	}
+	if to.Before(from) {
+		return fmt.Errorf("invalid backfill range %v - %v", from, to)
+	}
+
+	topic := "market." + symbol + ".kline." + string(period)
+	// 窗口起点不在K线边界上时, 服务器还会返回包含起点的那一根, 因此每个窗口少算一根
+	window := int64((KlineBatchSize - 1) * period.minDuration() / time.Second)
+	end := to.Unix()
+
+	// 已经交给listener的最后一根K线ID
+	var last int64 = -1
+
+	for cursor := from.Unix(); cursor <= end; cursor += window {
+		if cursor != from.Unix() {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(h.backfill):
+			}
+		}
+
+		chunkEnd := cursor + window - 1
+		if chunkEnd > end {
+			chunkEnd = end
+		}
+
+		msg, err := h.requestBackfill(ctx, topic, time.Unix(cursor, 0), time.Unix(chunkEnd, 0))
+		if err != nil {
+			return err
+		}
+		var rep marketKlineRep
+		if err := json.Unmarshal(msg, &rep); err != nil {
+			h.logger.Error("decode message failed", "topic", topic, "err", err)
+			h.metrics.IncDecodeFailure(topic)
+			return err
+		}
+
+		sort.Slice(rep.Data, func(i, j int) bool {
+			return rep.Data[i].ID < rep.Data[j].ID
+		})
+		klines := make([]Kline, 0, len(rep.Data))
+		for _, kline := range rep.Data {
+			if kline.ID <= last || kline.ID < from.Unix() || kline.ID > end {
+				continue
+			}
+			klines = append(klines, kline)
+			last = kline.ID
+		}
+		if len(klines) == 0 {
+			continue
+		}
+		if err := listener(symbol, period, klines); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// requestBackfill 请求一个窗口的历史数据, 触发频率限制时等待后重试, 等待时间从回补间隔开始逐次加倍
+func (h *Huobi) requestBackfill(ctx context.Context, topic string, from, to time.Time) ([]byte, error) {
+	delay := h.backfill
+	for retries := 0; ; retries++ {
+		msg, err := h.market.requestRangeRaw(topic, from, to)
+		if err != RequestRateLimitError || retries == backfillRetries {
+			return msg, err
+		}
+		h.logger.Warn("backfill rate limited", "topic", topic, "retry", retries+1, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// Trade 一笔成交
+type Trade struct {
+	TradeID   int64   `json:"trade-id"`
+	Amount    float64 `json:"amount"`
+	Price     float64 `json:"price"`
+	Direction string  `json:"direction"`
+	TS        int64   `json:"ts"`
+}
+
+type marketTradeRep struct {
+	Rep    string `json:"rep"`
+	Status string `json:"status"`
+	Data   []struct {
+		ID   int64   `json:"id"`
+		TS   int64   `json:"ts"`
+		Data []Trade `json:"data"`
+	} `json:"data"`
+}
+
+// RequestTrades 请求最近的成交记录, 按成交时间升序且不重复
+func (h *Huobi) RequestTrades(symbol string) ([]Trade, error) {
+	topic := "market." + symbol + ".trade.detail"
+	msg, err := h.market.requestRaw(topic)
+	if err != nil {
+		return nil, err
+	}
+	var rep marketTradeRep
+	if err := json.Unmarshal(msg, &rep); err != nil {
+		h.logger.Error("decode message failed", "topic", topic, "err", err)
+		h.metrics.IncDecodeFailure(topic)
+		return nil, err
+	}
+
+	seen := make(map[int64]bool)
+	var trades []Trade
+	for _, tick := range rep.Data {
+		for _, trade := range tick.Data {
+			if seen[trade.TradeID] {
+				continue
+			}
+			seen[trade.TradeID] = true
+			trades = append(trades, trade)
+		}
+	}
+	sort.SliceStable(trades, func(i, j int) bool {
+		if trades[i].TS != trades[j].TS {
+			return trades[i].TS < trades[j].TS
+		}
+		return trades[i].TradeID < trades[j].TradeID
+	})
+
+	return trades, nil
+}
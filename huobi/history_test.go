@@ -0,0 +1,161 @@
+package huobi_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leek-box/sheep/huobi"
+	"github.com/leek-box/sheep/huobi/huobitest"
+)
+
+// newMarketHuobi 创建只连接假行情服务器的Huobi, 不查询账户
+func newMarketHuobi(t *testing.T, opts ...huobi.Option) (*huobi.Huobi, *huobitest.Server) {
+	srv := huobitest.NewServer()
+	t.Cleanup(srv.Close)
+
+	opts = append([]huobi.Option{huobi.WithEndpoints(srv.URL), huobi.WithProxy(nil)}, opts...)
+	h, err := huobi.NewHuobi("", "", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, srv
+}
+
+// klineServer 按请求范围返回每分钟一根的K线, 与火币一样包含覆盖起点的那一根
+type klineServer struct {
+	mutex       sync.Mutex
+	requests    []huobitest.Request
+	rateLimited int // 之后的几次请求返回频率超限
+}
+
+func (s *klineServer) handle(req huobitest.Request) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.rateLimited > 0 {
+		s.rateLimited--
+		return nil, huobitest.NewError(huobitest.ErrCodeTooManyRequests, "too many requests")
+	}
+	s.requests = append(s.requests, req)
+
+	var klines []huobi.Kline
+	// 倒序返回, 客户端需自行排序
+	for id := req.To - req.To%60; id >= req.From-req.From%60; id -= 60 {
+		klines = append(klines, huobi.Kline{ID: id, Close: float64(id)})
+	}
+	if len(klines) > huobi.KlineBatchSize {
+		return nil, fmt.Errorf("%d klines exceed the limit", len(klines))
+	}
+	return klines, nil
+}
+
+func TestBackfillKlineChunks(t *testing.T) {
+	h, srv := newMarketHuobi(t, huobi.WithBackfillInterval(time.Millisecond))
+	ks := &klineServer{}
+	srv.HandleRequest("market.btcusdt.kline.1min", ks.handle)
+
+	// 起点不在分钟边界上, 每个窗口都会多返回一根
+	from := time.Unix(1600000000+30, 0)
+	to := from.Add(1000 * time.Minute)
+	var got []huobi.Kline
+	err := h.BackfillKline(context.Background(), "btcusdt", huobi.Period1Min, from, to, func(symbol string, period huobi.Period, klines []huobi.Kline) error {
+		got = append(got, klines...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ks.requests) < 4 {
+		t.Fatalf("%d requests for 1000 minutes, want at least 4", len(ks.requests))
+	}
+	for i := 1; i < len(ks.requests); i++ {
+		if ks.requests[i].From != ks.requests[i-1].To+1 {
+			t.Fatalf("request %d starts at %d, previous ended at %d", i, ks.requests[i].From, ks.requests[i-1].To)
+		}
+	}
+
+	// 窗口边界上重复返回的K线只交给listener一次, 且不超出[from, to]
+	first := from.Unix() - from.Unix()%60 + 60
+	want := int((to.Unix()-first)/60) + 1
+	if len(got) != want {
+		t.Fatalf("got %d klines, want %d", len(got), want)
+	}
+	for i, kline := range got {
+		if kline.ID != first+int64(i)*60 {
+			t.Fatalf("kline %d has id %d, want %d", i, kline.ID, first+int64(i)*60)
+		}
+	}
+}
+
+func TestBackfillKlineRateLimited(t *testing.T) {
+	h, srv := newMarketHuobi(t, huobi.WithBackfillInterval(time.Millisecond))
+	ks := &klineServer{rateLimited: 2}
+	srv.HandleRequest("market.btcusdt.kline.1min", ks.handle)
+
+	// 起点在分钟边界上
+	from := time.Unix(1600000020, 0)
+	var n int
+	err := h.BackfillKline(context.Background(), "btcusdt", huobi.Period1Min, from, from.Add(10*time.Minute), func(symbol string, period huobi.Period, klines []huobi.Kline) error {
+		n += len(klines)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("backfill failed after rate limiting: %v", err)
+	}
+	if n != 11 {
+		t.Fatalf("got %d klines, want 11", n)
+	}
+
+	// 一直超限时重试有限次后返回错误
+	ks.mutex.Lock()
+	ks.rateLimited = 100
+	ks.mutex.Unlock()
+	err = h.BackfillKline(context.Background(), "btcusdt", huobi.Period1Min, from, from.Add(10*time.Minute), func(symbol string, period huobi.Period, klines []huobi.Kline) error {
+		return nil
+	})
+	if err != huobi.RequestRateLimitError {
+		t.Fatalf("err = %v, want %v", err, huobi.RequestRateLimitError)
+	}
+}
+
+func TestRequestTrades(t *testing.T) {
+	h, srv := newMarketHuobi(t)
+	srv.HandleRequest("market.btcusdt.trade.detail", func(req huobitest.Request) (interface{}, error) {
+		// 两个tick中有一笔重复的成交, 且按时间倒序
+		return []map[string]interface{}{
+			{"id": 2, "ts": 2000, "data": []map[string]interface{}{
+				{"trade-id": 103, "amount": 0.3, "price": 10.3, "direction": "sell", "ts": 2000},
+				{"trade-id": 102, "amount": 0.2, "price": 10.2, "direction": "buy", "ts": 1500},
+			}},
+			{"id": 1, "ts": 1500, "data": []map[string]interface{}{
+				{"trade-id": 102, "amount": 0.2, "price": 10.2, "direction": "buy", "ts": 1500},
+				{"trade-id": 101, "amount": 0.1, "price": 10.1, "direction": "buy", "ts": 1000},
+			}},
+		}, nil
+	})
+
+	trades, err := h.RequestTrades("btcusdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []huobi.Trade{
+		{TradeID: 101, Amount: 0.1, Price: 10.1, Direction: "buy", TS: 1000},
+		{TradeID: 102, Amount: 0.2, Price: 10.2, Direction: "buy", TS: 1500},
+		{TradeID: 103, Amount: 0.3, Price: 10.3, Direction: "sell", TS: 2000},
+	}
+	if len(trades) != len(want) {
+		t.Fatalf("got %+v, want %+v", trades, want)
+	}
+	for i := range want {
+		if trades[i] != want[i] {
+			t.Fatalf("trade %d = %+v, want %+v", i, trades[i], want[i])
+		}
+	}
+
+	if _, err := h.RequestTrades("ethusdt"); err == nil {
+		t.Fatal("RequestTrades for an unknown topic succeeded")
+	}
+}
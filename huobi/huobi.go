@@ -6,8 +6,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-
-	"github.com/leizongmin/huobiapi"
+	"sync"
+	"time"
 )
 
 type MarketTradeDetail struct {
@@ -29,10 +29,49 @@ func (m *MarketTradeDetail) String() string {
 type MarketDepth struct {
 	Ch   string `json:"ch"`
 	Tick struct {
-		Asks [][]float64 `json:"asks"`
-		Bids [][]float64 `json:"bids"`
-		TS   int64       `json:"ts"`
+		Asks   [][]float64 `json:"asks"`
+		Bids   [][]float64 `json:"bids"`
+		TS     int64       `json:"ts"`
+		SeqNum int64       `json:"seqNum"` // 仅MBP推送有
 	} `json:"tick"`
+	Option DepthOption `json:"-"` // 该推送对应的订阅选项
+}
+
+// DepthStep 深度合并精度, step0为不合并
+type DepthStep string
+
+const (
+	DepthStep0 DepthStep = "step0"
+	DepthStep1 DepthStep = "step1"
+	DepthStep2 DepthStep = "step2"
+	DepthStep3 DepthStep = "step3"
+	DepthStep4 DepthStep = "step4"
+	DepthStep5 DepthStep = "step5"
+)
+
+// DepthOption 深度订阅选项
+// Levels为0时订阅按Step合并的深度 market.$symbol.depth.$step,
+// 否则订阅MBP快照 market.$symbol.mbp.refresh.$levels, Levels可选5, 10, 20, 此时忽略Step
+type DepthOption struct {
+	Step   DepthStep
+	Levels int
+}
+
+// topic 订阅主题
+func (o DepthOption) topic(symbol string) (string, error) {
+	if o.Levels != 0 {
+		switch o.Levels {
+		case 5, 10, 20:
+			return fmt.Sprintf("market.%s.mbp.refresh.%d", symbol, o.Levels), nil
+		}
+		return "", fmt.Errorf("invalid mbp levels %d", o.Levels)
+	}
+
+	switch o.Step {
+	case DepthStep0, DepthStep1, DepthStep2, DepthStep3, DepthStep4, DepthStep5:
+		return "market." + symbol + ".depth." + string(o.Step), nil
+	}
+	return "", fmt.Errorf("invalid depth step %q", o.Step)
 }
 
 type Account struct {
@@ -43,25 +82,111 @@ type Account struct {
 }
 
 type Huobi struct {
-	accessKey      string
-	secretKey      string
-	tradeAccount   Account
-	market         *Market
+	credentials   CredentialProvider
+	credentialsMu sync.RWMutex
+	rest          *RestClient
+	restHost      string
+	backfill      time.Duration // BackfillKline两次请求之间的间隔
+	logger        Logger
+	metrics       Metrics
+	tradeAccount  Account
+	market        *MarketPool
+	orders        *OrderManager
+	opts          []Option
+
+	private        *PrivateClient
+	privateMu      sync.Mutex
 	depthListener  DepthlListener
 	detailListener DetailListener
+	listenerMu     sync.RWMutex // 保护depthListener和detailListener, 推送在websocket读协程中读取它们
+	// SubscribeDetail/SubscribeDepth为全局监听器创建的订阅句柄, 重复订阅时只替换这些句柄
+	globalSubs   map[string]*Subscription
+	globalSubsMu sync.Mutex
 }
 
 func (h *Huobi) GetExchangeName() string {
 	return "HuobiPro"
 }
 
+// SetCredentialProvider 替换凭证提供者, 之后的请求使用新的凭证签名, 已有的websocket连接不受影响
+func (h *Huobi) SetCredentialProvider(provider CredentialProvider) {
+	h.credentialsMu.Lock()
+	defer h.credentialsMu.Unlock()
+	h.credentials = provider
+}
+
+// getCredentials 取当前凭证
+func (h *Huobi) getCredentials() (*Credentials, error) {
+	h.credentialsMu.RLock()
+	provider := h.credentials
+	h.credentialsMu.RUnlock()
+
+	return provider.Credentials()
+}
+
+// Private 返回资产和订单Websocket客户端, 首次调用时连接并鉴权
+// 客户端每次鉴权都使用Huobi当前的凭证, SetCredentialProvider后重连即生效
+func (h *Huobi) Private() (*PrivateClient, error) {
+	h.privateMu.Lock()
+	defer h.privateMu.Unlock()
+
+	if h.private != nil {
+		return h.private, nil
+	}
+
+	p, err := NewPrivateClient(CredentialProviderFunc(h.getCredentials), h.opts...)
+	if err != nil {
+		return nil, err
+	}
+	go p.Loop()
+
+	h.private = p
+	return p, nil
+}
+
+// OnStateChange 添加行情连接的状态监听器, 可据此在行情中断时暂停交易
+func (h *Huobi) OnStateChange(listener StateListener) {
+	h.market.OnStateChange(listener)
+}
+
+// Use 为REST请求追加中间件
+func (h *Huobi) Use(middlewares ...RestMiddleware) {
+	h.rest.Use(middlewares...)
+}
+
+// apiKeyGet 使用当前凭证进行签名后的HTTP GET请求
+func (h *Huobi) apiKeyGet(mapParams map[string]string, strRequestPath string) string {
+	return h.apiKeyRequest("GET", mapParams, strRequestPath)
+}
+
+// apiKeyPost 使用当前凭证进行签名后的HTTP POST请求
+func (h *Huobi) apiKeyPost(mapParams map[string]string, strRequestPath string) string {
+	return h.apiKeyRequest("POST", mapParams, strRequestPath)
+}
+
+// apiKeyRequest 签名后经过中间件链发出请求
+// return: 响应内容, 签名或网络错误时为火币格式的错误
+func (h *Huobi) apiKeyRequest(method string, mapParams map[string]string, strRequestPath string) string {
+	credentials, err := h.getCredentials()
+	if err != nil {
+		return (&RestResponse{Err: err}).body()
+	}
+
+	req := newRestRequest(h.restHost, method, strRequestPath, mapParams, credentials)
+	if err := req.Sign(); err != nil {
+		return (&RestResponse{Err: err}).body()
+	}
+
+	return h.rest.Do(req).body()
+}
+
 // 查询当前用户的所有账户, 根据包含的私钥查询
 // return: AccountsReturn对象
 func (h *Huobi) GetAccounts() AccountsReturn {
 	accountsReturn := AccountsReturn{}
 
 	strRequest := "/v1/account/accounts"
-	jsonAccountsReturn := apiKeyGet(make(map[string]string), strRequest, h.accessKey, h.secretKey)
+	jsonAccountsReturn := h.apiKeyGet(make(map[string]string), strRequest)
 	json.Unmarshal([]byte(jsonAccountsReturn), &accountsReturn)
 
 	return accountsReturn
@@ -72,7 +197,7 @@ func (h *Huobi) GetAccounts() AccountsReturn {
 func (h *Huobi) GetAccountBalance() (*Balance, error) {
 	balanceReturn := BalanceReturn{}
 	strRequest := fmt.Sprintf("/v1/account/accounts/%d/balance", h.tradeAccount.ID)
-	jsonBanlanceReturn := apiKeyGet(make(map[string]string), strRequest, h.accessKey, h.secretKey)
+	jsonBanlanceReturn := h.apiKeyGet(make(map[string]string), strRequest)
 	json.Unmarshal([]byte(jsonBanlanceReturn), &balanceReturn)
 	if balanceReturn.Status != "ok" {
 		return nil, errors.New(balanceReturn.ErrMsg)
@@ -107,13 +232,16 @@ func (h *Huobi) Place(amount, price float64, symbol, typ string) (string, error)
 	mapParams["type"] = placeRequestParams.Type
 
 	strRequest := "/v1/order/orders/place"
-	jsonPlaceReturn := apiKeyPost(mapParams, strRequest, h.accessKey, h.secretKey)
+	jsonPlaceReturn := h.apiKeyPost(mapParams, strRequest)
 	json.Unmarshal([]byte(jsonPlaceReturn), &placeReturn)
 
 	if placeReturn.Status != "ok" {
-		return "", errors.New(placeReturn.ErrMsg)
+		err := errors.New(placeReturn.ErrMsg)
+		h.orders.reject(symbol, typ, price, amount, err)
+		return "", err
 	}
 
+	h.orders.track(placeReturn.Data, symbol, typ, price, amount)
 	return placeReturn.Data, nil
 
 }
@@ -125,7 +253,7 @@ func (h *Huobi) SubmitCancel(strOrderID string) error {
 	placeReturn := PlaceReturn{}
 
 	strRequest := fmt.Sprintf("/v1/order/orders/%s/submitcancel", strOrderID)
-	jsonPlaceReturn := apiKeyPost(make(map[string]string), strRequest, h.accessKey, h.secretKey)
+	jsonPlaceReturn := h.apiKeyPost(make(map[string]string), strRequest)
 	json.Unmarshal([]byte(jsonPlaceReturn), &placeReturn)
 
 	if placeReturn.Status != "ok" {
@@ -142,7 +270,7 @@ func (h *Huobi) GetOrderInfo(strOrderID string) (*Order, error) {
 	orderReturn := OrderReturn{}
 
 	strRequest := fmt.Sprintf("/v1/order/orders/%s", strOrderID)
-	jsonPlaceReturn := apiKeyGet(make(map[string]string), strRequest, h.accessKey, h.secretKey)
+	jsonPlaceReturn := h.apiKeyGet(make(map[string]string), strRequest)
 	json.Unmarshal([]byte(jsonPlaceReturn), &orderReturn)
 
 	if orderReturn.Status != "ok" {
@@ -162,7 +290,7 @@ func (h *Huobi) GetOrders(params OrdersRequestParams) ([]Order, error) {
 	json.Unmarshal(jsonP, &paramMap)
 
 	strRequest := "/v1/order/orders"
-	ret := apiKeyGet(paramMap, strRequest, h.accessKey, h.secretKey)
+	ret := h.apiKeyGet(paramMap, strRequest)
 	json.Unmarshal([]byte(ret), &ordersReturn)
 	if ordersReturn.Status != "ok" {
 		return nil, errors.New(ordersReturn.ErrMsg)
@@ -172,67 +300,187 @@ func (h *Huobi) GetOrders(params OrdersRequestParams) ([]Order, error) {
 
 }
 
+// SetDetailListener 设置SubscribeDetail的监听器, 可在订阅后随时替换
 func (h *Huobi) SetDetailListener(listener DetailListener) {
+	h.listenerMu.Lock()
+	defer h.listenerMu.Unlock()
 	h.detailListener = listener
 }
 
+// SetDepthlListener 设置SubscribeDepth的监听器, 可在订阅后随时替换
 func (h *Huobi) SetDepthlListener(listener DepthlListener) {
+	h.listenerMu.Lock()
+	defer h.listenerMu.Unlock()
 	h.depthListener = listener
 }
 
+// getDetailListener 当前的成交明细监听器
+func (h *Huobi) getDetailListener() DetailListener {
+	h.listenerMu.RLock()
+	defer h.listenerMu.RUnlock()
+	return h.detailListener
+}
+
+// getDepthListener 当前的深度监听器
+func (h *Huobi) getDepthListener() DepthlListener {
+	h.listenerMu.RLock()
+	defer h.listenerMu.RUnlock()
+	return h.depthListener
+}
+
+// decodeTopic 将推送解码到v, 失败时记录日志和指标, 并通知该主题的订阅句柄
+func (h *Huobi) decodeTopic(topic string, msg []byte, v interface{}) error {
+	err := json.Unmarshal(msg, v)
+	if err != nil {
+		h.logger.Error("decode message failed", "topic", topic, "err", err)
+		h.metrics.IncDecodeFailure(topic)
+		h.market.reportError(topic, err)
+	}
+	return err
+}
+
+// subscribe 为全局监听器订阅主题, 替换之前为同一主题创建的全局订阅句柄
+// 同一主题上通过ListenRaw、DetailStream等创建的其他订阅句柄不受影响
+func (h *Huobi) subscribe(topic string, listener RawListener) error {
+	sub, err := h.market.ListenRaw(topic, listener)
+	if err != nil {
+		return err
+	}
+
+	h.globalSubsMu.Lock()
+	old := h.globalSubs[topic]
+	h.globalSubs[topic] = sub
+	h.globalSubsMu.Unlock()
+
+	// 新句柄已注册, 移除旧句柄不会取消主题订阅
+	if old != nil {
+		old.Unsubscribe()
+	}
+	return nil
+}
+
 // Listener 订阅事件监听器
 type DetailListener = func(symbol string, detail *MarketTradeDetail)
 
-func (h *Huobi) SubscribeDetail(symbols ...string) {
-	for _, symbol := range symbols {
-		h.market.Subscribe("market."+symbol+".trade.detail", func(topic string, j *huobiapi.JSON) {
-			js, _ := j.MarshalJSON()
-			var mtd MarketTradeDetail
-			err := json.Unmarshal(js, &mtd)
-			if err != nil {
-				fmt.Println(err.Error())
-			}
+// tradeDetailHandler 将推送解码为MarketTradeDetail后交给listener
+func (h *Huobi) tradeDetailHandler(listener DetailListener) RawListener {
+	return func(topic string, msg []byte) {
+		var mtd MarketTradeDetail
+		if h.decodeTopic(topic, msg, &mtd) != nil {
+			return
+		}
 
-			ts := strings.Split(topic, ".")
-			if h.detailListener != nil {
-				h.detailListener(ts[1], &mtd)
-			}
+		ts := strings.Split(topic, ".")
+		listener(ts[1], &mtd)
+	}
+}
 
-		})
+// SubscribeDetail 订阅成交明细, 推送给SetDetailListener设置的监听器
+// 某个交易对订阅失败时返回错误, 之后的交易对不再订阅
+func (h *Huobi) SubscribeDetail(symbols ...string) error {
+	for _, symbol := range symbols {
+		err := h.subscribe("market."+symbol+".trade.detail", h.tradeDetailHandler(func(symbol string, detail *MarketTradeDetail) {
+			if listener := h.getDetailListener(); listener != nil {
+				listener(symbol, detail)
+			}
+		}))
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// SubscribeDetailFunc 订阅成交明细 market.$symbol.trade.detail, 推送给listener
+func (h *Huobi) SubscribeDetailFunc(symbol string, listener DetailListener) (*Subscription, error) {
+	return h.market.ListenRaw("market."+symbol+".trade.detail", h.tradeDetailHandler(listener))
 }
 
 // Listener 订阅事件监听器
 type DepthlListener = func(symbol string, depth *MarketDepth)
 
-func (h *Huobi) SubscribeDepth(symbols ...string) {
+// depthHandler 将推送解码为MarketDepth后交给listener
+func (h *Huobi) depthHandler(option DepthOption, listener DepthlListener) RawListener {
+	return func(topic string, msg []byte) {
+		var md = MarketDepth{Option: option}
+		if h.decodeTopic(topic, msg, &md) != nil {
+			return
+		}
+
+		ts := strings.Split(topic, ".")
+		listener(ts[1], &md)
+	}
+}
+
+// SubscribeDepth 订阅step0深度, 推送给SetDepthlListener设置的监听器
+func (h *Huobi) SubscribeDepth(symbols ...string) error {
+	return h.SubscribeDepthWithOption(DepthOption{Step: DepthStep0}, symbols...)
+}
+
+// SubscribeDepthWithOption 按指定精度或档位订阅深度, 推送给SetDepthlListener设置的监听器
+// 同一交易对可以用不同选项同时订阅, 推送中的Option字段标明了来源
+func (h *Huobi) SubscribeDepthWithOption(option DepthOption, symbols ...string) error {
 	for _, symbol := range symbols {
-		h.market.Subscribe("market."+symbol+".depth.step0", func(topic string, j *huobiapi.JSON) {
-			js, _ := j.MarshalJSON()
-			var md = MarketDepth{}
-			err := json.Unmarshal(js, &md)
-			if err != nil {
-				fmt.Println(err.Error())
-			}
+		topic, err := option.topic(symbol)
+		if err != nil {
+			return err
+		}
 
-			ts := strings.Split(topic, ".")
-			if h.depthListener != nil {
-				h.depthListener(ts[1], &md)
+		err = h.subscribe(topic, h.depthHandler(option, func(symbol string, depth *MarketDepth) {
+			if listener := h.getDepthListener(); listener != nil {
+				listener(symbol, depth)
 			}
+		}))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		})
+// SubscribeDepthFunc 按指定精度或档位订阅深度, 推送给listener
+func (h *Huobi) SubscribeDepthFunc(symbol string, option DepthOption, listener DepthlListener) (*Subscription, error) {
+	topic, err := option.topic(symbol)
+	if err != nil {
+		return nil, err
 	}
+
+	return h.market.ListenRaw(topic, h.depthHandler(option, listener))
 }
 
-func NewHuobi(accesskey, secretkey string) (*Huobi, error) {
+func NewHuobi(accesskey, secretkey string, opts ...Option) (*Huobi, error) {
+	return NewHuobiWithSigner(accesskey, NewHmacSigner(secretkey), opts...)
+}
+
+// NewHuobiWithSigner 使用自定义签名器创建Huobi实例, 密钥不需要交给Huobi
+func NewHuobiWithSigner(accesskey string, signer Signer, opts ...Option) (*Huobi, error) {
+	return NewHuobiWithProvider(NewStaticProvider(accesskey, signer), opts...)
+}
+
+// NewHuobiWithProvider 使用凭证提供者创建Huobi实例
+// 每次请求都会向provider获取凭证, provider更换密钥后无需重建实例
+func NewHuobiWithProvider(provider CredentialProvider, opts ...Option) (*Huobi, error) {
+	o := newOptions(opts)
 	h := &Huobi{
-		accessKey: accesskey,
-		secretKey: secretkey,
+		credentials: provider,
+		rest:        NewRestClientWithHTTPClient(o.network.httpClient(), metricsMiddleware(o.metrics), loggingMiddleware(o.logger)),
+		restHost:    o.restHost,
+		backfill:    o.backfillInterval,
+		logger:      o.logger,
+		metrics:     o.metrics,
+		opts:        opts,
+		globalSubs:  make(map[string]*Subscription),
+	}
+	h.rest.Use(o.restMiddlewares...)
+	h.orders = newOrderManager(h)
+
+	credentials, err := provider.Credentials()
+	if err != nil {
+		return nil, err
 	}
 
-	if accesskey != "" {
-		fmt.Println("init huobi.")
+	if credentials.AccessKey != "" {
+		h.logger.Info("init huobi")
 		ret := h.GetAccounts()
 		if ret.Status != "ok" {
 			return nil, errors.New(ret.ErrMsg)
@@ -240,7 +488,7 @@ func NewHuobi(accesskey, secretkey string) (*Huobi, error) {
 
 		for _, account := range ret.Data {
 			if account.Type == "spot" {
-				fmt.Println("account id:", account.ID)
+				h.logger.Info("huobi spot account", "account-id", account.ID)
 				h.tradeAccount.ID = account.ID
 				h.tradeAccount.Type = account.Type
 				h.tradeAccount.State = account.State
@@ -251,15 +499,14 @@ func NewHuobi(accesskey, secretkey string) (*Huobi, error) {
 		}
 	}
 
-	var err error
-	h.market, err = NewMarket()
+	h.market, err = NewMarketPool(opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	go h.market.Loop()
 
-	fmt.Println("init huobi success.")
+	h.logger.Info("init huobi success")
 
 	return h, nil
 }
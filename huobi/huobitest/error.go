@@ -0,0 +1,25 @@
+package huobitest
+
+// 与火币一致的错误码
+const (
+	ErrCodeSignature        = "api-signature-not-valid"
+	ErrCodeInvalidParameter = "invalid-parameter"
+	ErrCodeRecordInvalid    = "base-record-invalid"
+	ErrCodeOrderState       = "order-orderstate-error"
+	ErrCodeTooManyRequests  = "too-many-request"
+)
+
+// Error 以火币错误格式返回的错误
+type Error struct {
+	Code string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Msg
+}
+
+// NewError 创建火币格式的错误
+func NewError(code, msg string) *Error {
+	return &Error{Code: code, Msg: msg}
+}
@@ -0,0 +1,588 @@
+package huobitest
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leek-box/sheep/huobi"
+)
+
+// 频率限制的响应头, 与火币一致
+const (
+	HeaderRateLimitRemain = "X-HB-RateLimit-Requests-Remain"
+	HeaderRateLimitExpire = "X-HB-RateLimit-Requests-Expire"
+)
+
+// 默认的时间戳有效范围及频率限制
+const (
+	DefaultTimestampWindow = 5 * time.Minute
+	DefaultRateLimit       = 100
+	DefaultRateWindow      = 2 * time.Second
+)
+
+// RestCall 一次通过签名校验的REST调用
+type RestCall struct {
+	Account *RestAccount
+	Method  string
+	Path    string
+	// GET为查询参数中的业务参数, POST为请求体中的JSON参数
+	Params map[string]string
+}
+
+// RestHandlerFunc 处理REST调用, 返回的data作为成功响应的data字段
+// 返回*Error时使用其中的错误码, 其他错误使用invalid-parameter
+type RestHandlerFunc = func(call *RestCall) (data interface{}, err error)
+
+// RestAccount 一个API Key及其现货账户
+type RestAccount struct {
+	ID        int64
+	UserID    int64
+	AccessKey string
+
+	secretKey string
+	publicKey *ecdsa.PublicKey
+
+	// 币种余额
+	trade  map[string]float64
+	frozen map[string]float64
+
+	server *RestServer
+}
+
+// SetBalance 设置币种的可用及冻结余额
+func (a *RestAccount) SetBalance(currency string, trade, frozen float64) {
+	a.server.mutex.Lock()
+	defer a.server.mutex.Unlock()
+	a.trade[currency] = trade
+	a.frozen[currency] = frozen
+}
+
+// restOrder 内存中的订单
+type restOrder struct {
+	huobi.Order
+	accountID int64
+}
+
+// RestServer 本地的REST接口服务器
+// 使用与客户端相同的算法校验签名, 拒绝过期的时间戳, 在内存中保存账户和订单, 返回火币格式的响应及频率限制响应头
+//
+//	srv := huobitest.NewRestServer()
+//	defer srv.Close()
+//	srv.AddAccount("access-key", "secret-key")
+//	h, err := huobi.NewHuobi("access-key", "secret-key", huobi.WithRestHost(srv.URL), huobi.WithEndpoints(market.URL))
+type RestServer struct {
+	// URL REST接口地址, 如http://127.0.0.1:12345
+	URL string
+
+	srv *httptest.Server
+
+	accounts      map[string]*RestAccount
+	orders        map[int64]*restOrder
+	handlers      map[string]RestHandlerFunc
+	nextAccountID int64
+	nextOrderID   int64
+
+	timestampWindow time.Duration
+	rateLimit       int
+	rateWindow      time.Duration
+	// 每个Access Key当前窗口的结束时间及已用次数
+	rateExpire map[string]time.Time
+	rateUsed   map[string]int
+
+	mutex sync.Mutex
+}
+
+// NewRestServer 创建并启动REST服务器
+func NewRestServer() *RestServer {
+	s := &RestServer{
+		accounts:        make(map[string]*RestAccount),
+		orders:          make(map[int64]*restOrder),
+		handlers:        make(map[string]RestHandlerFunc),
+		nextAccountID:   10000,
+		nextOrderID:     1000000,
+		timestampWindow: DefaultTimestampWindow,
+		rateLimit:       DefaultRateLimit,
+		rateWindow:      DefaultRateWindow,
+		rateExpire:      make(map[string]time.Time),
+		rateUsed:        make(map[string]int),
+	}
+
+	s.HandleFunc("GET", "/v1/account/accounts", s.getAccounts)
+	s.HandleFunc("GET", "/v1/account/accounts/{id}/balance", s.getBalance)
+	s.HandleFunc("POST", "/v1/order/orders/place", s.place)
+	s.HandleFunc("POST", "/v1/order/orders/{id}/submitcancel", s.submitCancel)
+	s.HandleFunc("GET", "/v1/order/orders/{id}", s.getOrder)
+	s.HandleFunc("GET", "/v1/order/orders", s.getOrders)
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close 关闭服务器
+func (s *RestServer) Close() {
+	s.srv.Close()
+}
+
+// AddAccount 添加使用HmacSHA256签名的API Key, 并为其创建现货账户
+func (s *RestServer) AddAccount(accessKey, secretKey string) *RestAccount {
+	return s.addAccount(&RestAccount{AccessKey: accessKey, secretKey: secretKey})
+}
+
+// AddECDSAAccount 添加使用ECDSA签名的API Key, 并为其创建现货账户
+func (s *RestServer) AddECDSAAccount(accessKey string, publicKey *ecdsa.PublicKey) *RestAccount {
+	return s.addAccount(&RestAccount{AccessKey: accessKey, publicKey: publicKey})
+}
+
+func (s *RestServer) addAccount(a *RestAccount) *RestAccount {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nextAccountID++
+	a.ID = s.nextAccountID
+	a.UserID = s.nextAccountID + 1000
+	a.trade = make(map[string]float64)
+	a.frozen = make(map[string]float64)
+	a.server = s
+	s.accounts[a.AccessKey] = a
+	return a
+}
+
+// HandleFunc 设置接口的处理函数, 可以替换内置的接口
+// path中的{id}匹配任意一段路径, 匹配到的值以"id"为键放入Params
+func (s *RestServer) HandleFunc(method, path string, handler RestHandlerFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.handlers[method+" "+path] = handler
+}
+
+// SetTimestampWindow 设置Timestamp与服务器时间允许的最大偏差
+func (s *RestServer) SetTimestampWindow(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.timestampWindow = d
+}
+
+// SetRateLimit 设置每个Access Key在window内最多的请求数
+func (s *RestServer) SetRateLimit(limit int, window time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rateLimit = limit
+	s.rateWindow = window
+	s.rateExpire = make(map[string]time.Time)
+	s.rateUsed = make(map[string]int)
+}
+
+// Order 查询订单
+func (s *RestServer) Order(id int64) (huobi.Order, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		return huobi.Order{}, false
+	}
+	return o.Order, true
+}
+
+// Orders 所有订单, 按ID升序
+func (s *RestServer) Orders() []huobi.Order {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	orders := make([]huobi.Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		orders = append(orders, o.Order)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	return orders
+}
+
+// FillOrder 模拟成交amount数量, 订单变为partial-filled或filled
+func (s *RestServer) FillOrder(id int64, amount float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		return NewError(ErrCodeRecordInvalid, "record invalid")
+	}
+	if !orderOpen(o.State) {
+		return NewError(ErrCodeOrderState, "the order state is error")
+	}
+
+	total, _ := strconv.ParseFloat(o.Amount, 64)
+	filled, _ := strconv.ParseFloat(o.FieldAmount, 64)
+	filled += amount
+	if filled >= total {
+		filled = total
+		o.State = "filled"
+	} else {
+		o.State = "partial-filled"
+	}
+	o.FieldAmount = formatFloat(filled)
+	return nil
+}
+
+// SetOrderState 直接设置订单状态
+func (s *RestServer) SetOrderState(id int64, state string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		return NewError(ErrCodeRecordInvalid, "record invalid")
+	}
+	o.State = state
+	return nil
+}
+
+func orderOpen(state string) bool {
+	return state == "submitted" || state == "partial-filled"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// envelope 火币格式的响应
+type envelope struct {
+	Status  string      `json:"status"`
+	Data    interface{} `json:"data"`
+	ErrCode string      `json:"err-code,omitempty"`
+	ErrMsg  string      `json:"err-msg,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = NewError(ErrCodeInvalidParameter, err.Error())
+	}
+	writeJSON(w, code, envelope{Status: "error", ErrCode: e.Code, ErrMsg: e.Msg})
+}
+
+func (s *RestServer) serve(w http.ResponseWriter, r *http.Request) {
+	handler, pathParams, ok := s.route(r.Method, r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, NewError(ErrCodeInvalidParameter, "invalid path "+r.URL.Path))
+		return
+	}
+
+	query := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		query[k] = v[0]
+	}
+
+	account, err := s.verify(r.Method, r.Host, r.URL.Path, query)
+	if err != nil {
+		writeError(w, http.StatusOK, err)
+		return
+	}
+
+	if !s.allow(w, account.AccessKey) {
+		writeError(w, http.StatusTooManyRequests, NewError(ErrCodeTooManyRequests, "too many requests"))
+		return
+	}
+
+	// 业务参数
+	params := make(map[string]string)
+	if r.Method == "POST" {
+		body, _ := ioutil.ReadAll(r.Body)
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &params); err != nil {
+				writeError(w, http.StatusOK, NewError(ErrCodeInvalidParameter, "invalid json body"))
+				return
+			}
+		}
+	} else {
+		for k, v := range query {
+			params[k] = v
+		}
+		for _, k := range []string{"AccessKeyId", "SignatureMethod", "SignatureVersion", "Timestamp", "Signature"} {
+			delete(params, k)
+		}
+	}
+	for k, v := range pathParams {
+		params[k] = v
+	}
+
+	data, err := handler(&RestCall{Account: account, Method: r.Method, Path: r.URL.Path, Params: params})
+	if err != nil {
+		writeError(w, http.StatusOK, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, envelope{Status: "ok", Data: data})
+}
+
+// route 按方法和路径查找处理函数, 完全匹配优先
+func (s *RestServer) route(method, path string) (RestHandlerFunc, map[string]string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if h, ok := s.handlers[method+" "+path]; ok {
+		return h, nil, true
+	}
+
+	segments := strings.Split(path, "/")
+	for key, h := range s.handlers {
+		pattern := strings.SplitN(key, " ", 2)
+		if pattern[0] != method {
+			continue
+		}
+		parts := strings.Split(pattern[1], "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		params := make(map[string]string)
+		matched := true
+		for i, part := range parts {
+			if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+				params[strings.Trim(part, "{}")] = segments[i]
+			} else if part != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return h, params, true
+		}
+	}
+	return nil, nil, false
+}
+
+// verify 校验签名参数及时间戳, 返回API Key对应的账户
+func (s *RestServer) verify(method, host, path string, query map[string]string) (*RestAccount, error) {
+	signature := query["Signature"]
+	if signature == "" || query["SignatureVersion"] != "2" {
+		return nil, NewError(ErrCodeSignature, "Signature not valid: missing required parameter")
+	}
+
+	s.mutex.Lock()
+	account, ok := s.accounts[query["AccessKeyId"]]
+	window := s.timestampWindow
+	s.mutex.Unlock()
+	if !ok {
+		return nil, NewError(ErrCodeSignature, "Signature not valid: Incorrect Access key")
+	}
+
+	ts, err := time.Parse("2006-01-02T15:04:05", query["Timestamp"])
+	if err != nil {
+		return nil, NewError(ErrCodeSignature, "Signature not valid: invalid Timestamp")
+	}
+	if d := time.Since(ts); d > window || d < -window {
+		return nil, NewError(ErrCodeSignature, "Signature not valid: Timestamp expired")
+	}
+
+	params := make(map[string]string, len(query))
+	for k, v := range query {
+		if k != "Signature" {
+			params[k] = v
+		}
+	}
+	payload := huobi.SignaturePayload(params, method, host, path)
+
+	if !account.verifySignature(query["SignatureMethod"], payload, signature) {
+		return nil, NewError(ErrCodeSignature, "Signature not valid: Verification failure")
+	}
+	return account, nil
+}
+
+func (a *RestAccount) verifySignature(method, payload, signature string) bool {
+	switch {
+	case method == "HmacSHA256" && a.publicKey == nil:
+		expected, _ := huobi.NewHmacSigner(a.secretKey).Sign(payload)
+		return hmac.Equal([]byte(expected), []byte(signature))
+	case method == "ECDSA" && a.publicKey != nil:
+		sig, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil {
+			return false
+		}
+		digest := sha256.Sum256([]byte(payload))
+		return ecdsa.VerifyASN1(a.publicKey, digest[:], sig)
+	}
+	return false
+}
+
+// allow 按固定窗口计数, 并写入频率限制响应头
+func (s *RestServer) allow(w http.ResponseWriter, accessKey string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	expire, ok := s.rateExpire[accessKey]
+	if !ok || !now.Before(expire) {
+		expire = now.Add(s.rateWindow)
+		s.rateExpire[accessKey] = expire
+		s.rateUsed[accessKey] = 0
+	}
+
+	allowed := s.rateUsed[accessKey] < s.rateLimit
+	if allowed {
+		s.rateUsed[accessKey]++
+	}
+	w.Header().Set(HeaderRateLimitRemain, strconv.Itoa(s.rateLimit-s.rateUsed[accessKey]))
+	w.Header().Set(HeaderRateLimitExpire, strconv.FormatInt(expire.UnixNano()/int64(time.Millisecond), 10))
+	return allowed
+}
+
+func (s *RestServer) getAccounts(call *RestCall) (interface{}, error) {
+	a := call.Account
+	return []huobi.AccountsData{{ID: a.ID, Type: "spot", State: "working", UserID: a.UserID}}, nil
+}
+
+// ownAccount 取调用方自己的账户
+func (s *RestServer) ownAccount(call *RestCall, id string) (*RestAccount, error) {
+	accountID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || accountID != call.Account.ID {
+		return nil, NewError(ErrCodeRecordInvalid, "record invalid")
+	}
+	return call.Account, nil
+}
+
+func (s *RestServer) getBalance(call *RestCall) (interface{}, error) {
+	a, err := s.ownAccount(call, call.Params["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var currencies []string
+	for currency := range a.trade {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	balance := huobi.Balance{ID: a.ID, State: "working", Type: "spot", UserID: a.UserID, List: []huobi.SubAccount{}}
+	for _, currency := range currencies {
+		balance.List = append(balance.List,
+			huobi.SubAccount{Currency: currency, Type: "trade", Balance: formatFloat(a.trade[currency])},
+			huobi.SubAccount{Currency: currency, Type: "frozen", Balance: formatFloat(a.frozen[currency])},
+		)
+	}
+	return balance, nil
+}
+
+var orderTypes = map[string]bool{
+	"buy-market":  true,
+	"sell-market": true,
+	"buy-limit":   true,
+	"sell-limit":  true,
+}
+
+func (s *RestServer) place(call *RestCall) (interface{}, error) {
+	p := call.Params
+	a, err := s.ownAccount(call, p["account-id"])
+	if err != nil {
+		return nil, err
+	}
+	if p["symbol"] == "" {
+		return nil, NewError(ErrCodeInvalidParameter, "symbol is required")
+	}
+	if !orderTypes[p["type"]] {
+		return nil, NewError(ErrCodeInvalidParameter, fmt.Sprintf("invalid order type %q", p["type"]))
+	}
+	if amount, err := strconv.ParseFloat(p["amount"], 64); err != nil || amount <= 0 {
+		return nil, NewError(ErrCodeInvalidParameter, "invalid amount")
+	}
+	if strings.HasSuffix(p["type"], "-limit") {
+		if price, err := strconv.ParseFloat(p["price"], 64); err != nil || price <= 0 {
+			return nil, NewError(ErrCodeInvalidParameter, "invalid price")
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nextOrderID++
+	o := &restOrder{
+		Order: huobi.Order{
+			ID:          s.nextOrderID,
+			Symbol:      p["symbol"],
+			State:       "submitted",
+			Amount:      p["amount"],
+			FieldAmount: "0",
+			Price:       p["price"],
+			Type:        p["type"],
+		},
+		accountID: a.ID,
+	}
+	s.orders[o.ID] = o
+	return strconv.FormatInt(o.ID, 10), nil
+}
+
+// ownOrder 取调用方账户下的订单, 调用方需持有s.mutex
+func (s *RestServer) ownOrder(call *RestCall) (*restOrder, error) {
+	id, err := strconv.ParseInt(call.Params["id"], 10, 64)
+	if err != nil {
+		return nil, NewError(ErrCodeRecordInvalid, "record invalid")
+	}
+	o, ok := s.orders[id]
+	if !ok || o.accountID != call.Account.ID {
+		return nil, NewError(ErrCodeRecordInvalid, "record invalid")
+	}
+	return o, nil
+}
+
+func (s *RestServer) submitCancel(call *RestCall) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	o, err := s.ownOrder(call)
+	if err != nil {
+		return nil, err
+	}
+	switch o.State {
+	case "submitted":
+		o.State = "canceled"
+	case "partial-filled":
+		o.State = "partial-canceled"
+	default:
+		return nil, NewError(ErrCodeOrderState, "the order state is error")
+	}
+	return strconv.FormatInt(o.ID, 10), nil
+}
+
+func (s *RestServer) getOrder(call *RestCall) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	o, err := s.ownOrder(call)
+	if err != nil {
+		return nil, err
+	}
+	return o.Order, nil
+}
+
+func (s *RestServer) getOrders(call *RestCall) (interface{}, error) {
+	symbol, states := call.Params["symbol"], call.Params["states"]
+	if symbol == "" || states == "" {
+		return nil, NewError(ErrCodeInvalidParameter, "symbol and states are required")
+	}
+	wanted := make(map[string]bool)
+	for _, state := range strings.Split(states, ",") {
+		wanted[state] = true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	orders := []huobi.Order{}
+	for _, o := range s.orders {
+		if o.accountID == call.Account.ID && o.Symbol == symbol && wanted[o.State] {
+			orders = append(orders, o.Order)
+		}
+	}
+	// 火币按ID倒序返回
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID > orders[j].ID })
+	return orders, nil
+}
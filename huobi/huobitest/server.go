@@ -0,0 +1,550 @@
+// Package huobitest 提供本地的火币行情Websocket服务器, 用于集成测试
+//
+// 服务器实现了行情Websocket的协议: gzip压缩的消息、ping/pong、sub/subbed、unsub/unsubbed、req/rep及错误状态,
+// 推送内容由测试代码控制, 并可以注入丢弃心跳、断开连接、延迟应答及发送错误消息等故障.
+//
+//	srv := huobitest.NewServer()
+//	defer srv.Close()
+//	m, err := huobi.NewMarket(huobi.WithEndpoints(srv.URL))
+package huobitest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultPingInterval 服务器主动发送ping的默认间隔
+const DefaultPingInterval = 5 * time.Second
+
+// Request 客户端发来的req请求
+type Request struct {
+	Topic string
+	ID    string
+	From  int64
+	To    int64
+}
+
+// RequestHandler 处理req请求, 返回的data作为rep消息的data字段, 返回错误时应答错误状态
+// 错误为*Error时使用其中的err-code, 如ErrCodeTooManyRequests, 否则为bad-request
+type RequestHandler = func(req Request) (data interface{}, err error)
+
+// Server 本地的行情Websocket服务器, 所有方法都是并发安全的
+type Server struct {
+	// URL 行情入口, 如ws://127.0.0.1:12345/ws
+	URL string
+
+	srv *httptest.Server
+
+	conns    map[*conn]bool
+	handlers map[string]RequestHandler
+
+	// 故障注入
+	pingInterval time.Duration
+	dropPings    bool
+	ackDelay     time.Duration
+	failSubs     map[string]string
+	reject       bool
+
+	// 统计
+	accepted int
+	pongs    int
+
+	// 状态变化时关闭并替换, 用于等待
+	changed chan struct{}
+	mutex   sync.Mutex
+}
+
+// conn 一个客户端连接
+type conn struct {
+	ws     *websocket.Conn
+	topics map[string]bool
+
+	// 连接结束时关闭
+	done chan struct{}
+	once sync.Once
+
+	writeMutex sync.Mutex
+}
+
+// NewServer 创建并启动服务器
+func NewServer() *Server {
+	s := &Server{
+		conns:        make(map[*conn]bool),
+		handlers:     make(map[string]RequestHandler),
+		pingInterval: DefaultPingInterval,
+		failSubs:     make(map[string]string),
+		changed:      make(chan struct{}),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/ws"
+	return s
+}
+
+// Close 断开所有连接并关闭服务器
+func (s *Server) Close() {
+	s.Disconnect()
+	s.srv.Close()
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	reject := s.reject
+	s.mutex.Unlock()
+	if reject {
+		http.Error(w, "connection rejected by huobitest", http.StatusServiceUnavailable)
+		return
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &conn{
+		ws:     ws,
+		topics: make(map[string]bool),
+		done:   make(chan struct{}),
+	}
+
+	s.mutex.Lock()
+	s.conns[c] = true
+	s.accepted++
+	s.notify()
+	s.mutex.Unlock()
+
+	go s.pingLoop(c)
+	s.readLoop(c)
+
+	c.close()
+	s.mutex.Lock()
+	delete(s.conns, c)
+	s.notify()
+	s.mutex.Unlock()
+}
+
+// notify 唤醒所有等待者, 调用方需持有s.mutex
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// waitFor 等待cond成立
+func (s *Server) waitFor(ctx context.Context, cond func() bool) error {
+	for {
+		s.mutex.Lock()
+		ok := cond()
+		changed := s.changed
+		s.mutex.Unlock()
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// clientMessage 客户端发来的消息
+type clientMessage struct {
+	Ping  int64  `json:"ping"`
+	Pong  int64  `json:"pong"`
+	Sub   string `json:"sub"`
+	Unsub string `json:"unsub"`
+	Req   string `json:"req"`
+	ID    string `json:"id"`
+	From  int64  `json:"from"`
+	To    int64  `json:"to"`
+}
+
+func (s *Server) readLoop(c *conn) {
+	for {
+		_, b, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg clientMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			c.send(errorMessage("", "bad-request", "invalid json"))
+			continue
+		}
+
+		switch {
+		case msg.Ping > 0:
+			if !s.droppingPings() {
+				c.send(map[string]int64{"pong": msg.Ping})
+			}
+		case msg.Pong > 0:
+			s.mutex.Lock()
+			s.pongs++
+			s.notify()
+			s.mutex.Unlock()
+		case msg.Sub != "":
+			s.delayed(c, func() { s.subscribe(c, msg.Sub, msg.ID) })
+		case msg.Unsub != "":
+			s.delayed(c, func() { s.unsubscribe(c, msg.Unsub, msg.ID) })
+		case msg.Req != "":
+			req := Request{Topic: msg.Req, ID: msg.ID, From: msg.From, To: msg.To}
+			s.delayed(c, func() { s.request(c, req) })
+		}
+	}
+}
+
+// delayed 按DelayAcks设置的时间延迟应答, 不阻塞读取
+func (s *Server) delayed(c *conn, fn func()) {
+	s.mutex.Lock()
+	delay := s.ackDelay
+	s.mutex.Unlock()
+	if delay <= 0 {
+		fn()
+		return
+	}
+
+	go func() {
+		select {
+		case <-c.done:
+		case <-time.After(delay):
+			fn()
+		}
+	}()
+}
+
+func (s *Server) subscribe(c *conn, topic, id string) {
+	s.mutex.Lock()
+	errMsg, fail := s.failSubs[topic]
+	if !fail {
+		c.topics[topic] = true
+		s.notify()
+	}
+	s.mutex.Unlock()
+
+	if fail {
+		c.send(errorMessage(id, "bad-request", errMsg))
+		return
+	}
+	c.send(map[string]interface{}{"id": id, "status": "ok", "subbed": topic, "ts": now()})
+}
+
+func (s *Server) unsubscribe(c *conn, topic, id string) {
+	s.mutex.Lock()
+	subscribed := c.topics[topic]
+	delete(c.topics, topic)
+	s.notify()
+	s.mutex.Unlock()
+
+	if !subscribed {
+		c.send(errorMessage(id, "bad-request", "unsub with not subbed topic "+topic))
+		return
+	}
+	c.send(map[string]interface{}{"id": id, "status": "ok", "unsubbed": topic, "ts": now()})
+}
+
+func (s *Server) request(c *conn, req Request) {
+	s.mutex.Lock()
+	handler, ok := s.handlers[req.Topic]
+	s.mutex.Unlock()
+	if !ok {
+		c.send(errorMessage(req.ID, "bad-request", "invalid topic "+req.Topic))
+		return
+	}
+
+	data, err := handler(req)
+	if e, ok := err.(*Error); ok {
+		c.send(errorMessage(req.ID, e.Code, e.Msg))
+		return
+	}
+	if err != nil {
+		c.send(errorMessage(req.ID, "bad-request", err.Error()))
+		return
+	}
+	c.send(map[string]interface{}{"id": req.ID, "status": "ok", "rep": req.Topic, "ts": now(), "data": data})
+}
+
+func errorMessage(id, code, msg string) map[string]interface{} {
+	return map[string]interface{}{"id": id, "status": "error", "err-code": code, "err-msg": msg, "ts": now()}
+}
+
+// pingLoop 按间隔发送ping, DropPings期间不发送
+func (s *Server) pingLoop(c *conn) {
+	for {
+		s.mutex.Lock()
+		interval := s.pingInterval
+		s.mutex.Unlock()
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(interval):
+		}
+		if !s.droppingPings() {
+			c.send(map[string]int64{"ping": now()})
+		}
+	}
+}
+
+func (s *Server) droppingPings() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dropPings
+}
+
+// send 发送gzip压缩的JSON消息
+func (c *conn) send(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.sendRaw(gzipData(b))
+}
+
+// sendRaw 原样发送一帧
+func (c *conn) sendRaw(frame []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.ws.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// close 不发送关闭帧直接断开, 模拟网络中断
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.ws.UnderlyingConn().Close()
+	})
+}
+
+func gzipData(b []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// subscribers 订阅了主题的连接
+func (s *Server) subscribers(topic string) []*conn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var conns []*conn
+	for c := range s.conns {
+		if c.topics[topic] {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// all 所有连接
+func (s *Server) all() []*conn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// push 推送消息, ch必须是第一个字段, 与火币一致
+type push struct {
+	Ch   string      `json:"ch"`
+	TS   int64       `json:"ts"`
+	Tick interface{} `json:"tick"`
+}
+
+// Push 向订阅了topic的所有连接推送{"ch": topic, "ts": ..., "tick": tick}, 返回推送的连接数
+func (s *Server) Push(topic string, tick interface{}) int {
+	b, err := json.Marshal(push{Ch: topic, TS: now(), Tick: tick})
+	if err != nil {
+		panic(fmt.Sprintf("huobitest: marshal push: %v", err))
+	}
+	return s.PushRaw(topic, b)
+}
+
+// PushRaw 向订阅了topic的所有连接推送msg, msg为未压缩的完整JSON消息, 如data字段的market.tickers
+func (s *Server) PushRaw(topic string, msg []byte) int {
+	frame := gzipData(msg)
+	n := 0
+	for _, c := range s.subscribers(topic) {
+		if c.sendRaw(frame) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// HandleRequest 设置主题的req请求处理函数, 未设置的主题应答invalid topic错误
+func (s *Server) HandleRequest(topic string, handler RequestHandler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.handlers[topic] = handler
+}
+
+// SetPingInterval 设置发送ping的间隔, 从下一次ping开始生效
+func (s *Server) SetPingInterval(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pingInterval = d
+}
+
+// DropPings 为true时不再发送ping, 也不应答客户端的ping, 用于触发客户端的心跳超时
+func (s *Server) DropPings(drop bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dropPings = drop
+}
+
+// DelayAcks 延迟subbed、unsubbed及rep应答, 0表示立即应答
+func (s *Server) DelayAcks(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ackDelay = d
+}
+
+// FailSubscribe 订阅topic时应答错误状态, errMsg为空时恢复正常
+func (s *Server) FailSubscribe(topic, errMsg string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if errMsg == "" {
+		delete(s.failSubs, topic)
+		return
+	}
+	s.failSubs[topic] = errMsg
+}
+
+// RejectConnections 为true时拒绝新的连接, 用于测试重连退避
+func (s *Server) RejectConnections(reject bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reject = reject
+}
+
+// Disconnect 不发送关闭帧直接断开所有连接, 返回断开的连接数
+func (s *Server) Disconnect() int {
+	conns := s.all()
+	for _, c := range conns {
+		c.close()
+	}
+	return len(conns)
+}
+
+// DisconnectTopic 断开订阅了topic的连接, 用于测试连接池中单个连接故障, 返回断开的连接数
+func (s *Server) DisconnectTopic(topic string) int {
+	conns := s.subscribers(topic)
+	for _, c := range conns {
+		c.close()
+	}
+	return len(conns)
+}
+
+// SendRaw 向所有连接原样发送一帧, 不做压缩
+func (s *Server) SendRaw(frame []byte) {
+	for _, c := range s.all() {
+		c.sendRaw(frame)
+	}
+}
+
+// SendMalformed 向所有连接发送一帧无法解压的数据和一帧无法解析的JSON
+func (s *Server) SendMalformed() {
+	s.SendRaw([]byte("not gzip"))
+	s.SendRaw(gzipData([]byte(`{"ch":`)))
+}
+
+// Connections 当前的连接数
+func (s *Server) Connections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.conns)
+}
+
+// Accepted 累计接受的连接数, 可用于确认发生了重连
+func (s *Server) Accepted() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.accepted
+}
+
+// Pongs 累计收到的pong数
+func (s *Server) Pongs() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.pongs
+}
+
+// Subscribed 是否有连接订阅了topic
+func (s *Server) Subscribed(topic string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.subscribed(topic)
+}
+
+func (s *Server) subscribed(topic string) bool {
+	for c := range s.conns {
+		if c.topics[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+// Topics 所有连接订阅的主题, 按字母排序
+func (s *Server) Topics() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	set := make(map[string]bool)
+	for c := range s.conns {
+		for topic := range c.topics {
+			set[topic] = true
+		}
+	}
+	topics := make([]string, 0, len(set))
+	for topic := range set {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// WaitConnections 等待当前连接数达到n
+func (s *Server) WaitConnections(ctx context.Context, n int) error {
+	return s.waitFor(ctx, func() bool { return len(s.conns) == n })
+}
+
+// WaitAccepted 等待累计接受的连接数达到n
+func (s *Server) WaitAccepted(ctx context.Context, n int) error {
+	return s.waitFor(ctx, func() bool { return s.accepted >= n })
+}
+
+// WaitSubscribed 等待有连接订阅topic
+func (s *Server) WaitSubscribed(ctx context.Context, topic string) error {
+	return s.waitFor(ctx, func() bool { return s.subscribed(topic) })
+}
+
+// WaitUnsubscribed 等待所有连接都不再订阅topic
+func (s *Server) WaitUnsubscribed(ctx context.Context, topic string) error {
+	return s.waitFor(ctx, func() bool { return !s.subscribed(topic) })
+}
+
+// WaitPongs 等待累计收到的pong数达到n
+func (s *Server) WaitPongs(ctx context.Context, n int) error {
+	return s.waitFor(ctx, func() bool { return s.pongs >= n })
+}
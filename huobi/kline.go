@@ -0,0 +1,105 @@
+package huobi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Period K线周期
+type Period string
+
+const (
+	Period1Min  Period = "1min"
+	Period5Min  Period = "5min"
+	Period15Min Period = "15min"
+	Period30Min Period = "30min"
+	Period60Min Period = "60min"
+	Period4Hour Period = "4hour"
+	Period1Day  Period = "1day"
+	Period1Week Period = "1week"
+	Period1Mon  Period = "1mon"
+	Period1Year Period = "1year"
+)
+
+// Periods 所有支持的K线周期
+var Periods = []Period{
+	Period1Min, Period5Min, Period15Min, Period30Min, Period60Min,
+	Period4Hour, Period1Day, Period1Week, Period1Mon, Period1Year,
+}
+
+// Valid 是否为火币支持的周期
+func (p Period) Valid() bool {
+	for _, period := range Periods {
+		if p == period {
+			return true
+		}
+	}
+	return false
+}
+
+// Kline K线
+type Kline struct {
+	ID     int64   `json:"id"`     // K线ID, 即该周期起始时间的秒级时间戳
+	Open   float64 `json:"open"`   // 开盘价
+	Close  float64 `json:"close"`  // 收盘价, 未完结时为最新价
+	Low    float64 `json:"low"`    // 最低价
+	High   float64 `json:"high"`   // 最高价
+	Amount float64 `json:"amount"` // 成交量
+	Vol    float64 `json:"vol"`    // 成交额
+	Count  int64   `json:"count"`  // 成交笔数
+}
+
+type MarketKline struct {
+	Ch   string `json:"ch"`
+	TS   int64  `json:"ts"`
+	Tick Kline  `json:"tick"`
+}
+
+// KlineListener K线监听器
+// 每次K线更新时closed为false; 新的一根K线开始时, 上一根K线以closed为true再推送一次, 此时的数据为最终值
+type KlineListener = func(symbol string, period Period, kline *Kline, closed bool)
+
+// SubscribeKline 订阅K线 market.$symbol.kline.$period
+func (h *Huobi) SubscribeKline(symbol string, period Period, listener KlineListener) (*Subscription, error) {
+	if !period.Valid() {
+		return nil, fmt.Errorf("invalid kline period %q", period)
+	}
+
+	// 只在websocket读协程中访问
+	var state klineState
+
+	return h.market.ListenRaw("market."+symbol+".kline."+string(period), func(topic string, msg []byte) {
+		var mk MarketKline
+		if h.decodeTopic(topic, msg, &mk) != nil {
+			return
+		}
+
+		ts := strings.Split(topic, ".")
+		state.update(mk.Tick, func(kline *Kline, closed bool) {
+			listener(ts[1], period, kline, closed)
+		})
+	})
+}
+
+// klineState 记录当前未完结的K线, 新的一根K线开始时产生上一根的closed推送
+type klineState struct {
+	last *Kline
+}
+
+// update 处理一次K线推送, 每次交给emit的都是新的副本, 监听器保留的K线不会被之后的推送修改
+func (s *klineState) update(kline Kline, emit func(kline *Kline, closed bool)) {
+	if s.last != nil {
+		// 忽略乱序到达的旧K线
+		if kline.ID < s.last.ID {
+			return
+		}
+		if kline.ID > s.last.ID {
+			closed := *s.last
+			emit(&closed, true)
+		}
+	}
+	s.last = &kline
+
+	update := kline
+	emit(&update, false)
+}
@@ -0,0 +1,60 @@
+package huobi
+
+import "testing"
+
+type klineEvent struct {
+	kline  *Kline
+	closed bool
+}
+
+func TestKlineClosedOnce(t *testing.T) {
+	var state klineState
+	var events []klineEvent
+	emit := func(kline *Kline, closed bool) {
+		events = append(events, klineEvent{kline, closed})
+	}
+
+	state.update(Kline{ID: 60, Close: 1}, emit)
+	state.update(Kline{ID: 60, Close: 2}, emit)
+	// 乱序到达的旧K线被忽略
+	state.update(Kline{ID: 0, Close: 9}, emit)
+	state.update(Kline{ID: 120, Close: 3}, emit)
+	state.update(Kline{ID: 120, Close: 4}, emit)
+	state.update(Kline{ID: 180, Close: 5}, emit)
+
+	want := []struct {
+		id     int64
+		close  float64
+		closed bool
+	}{
+		{60, 1, false},
+		{60, 2, false},
+		{60, 2, true},
+		{120, 3, false},
+		{120, 4, false},
+		{120, 4, true},
+		{180, 5, false},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.kline.ID != w.id || e.kline.Close != w.close || e.closed != w.closed {
+			t.Fatalf("event %d = {%d %v %v}, want %+v", i, e.kline.ID, e.kline.Close, e.closed, w)
+		}
+	}
+
+	// 每次推送都是独立的副本, 保留下来的K线不会被之后的推送修改
+	for i := range events {
+		for j := i + 1; j < len(events); j++ {
+			if events[i].kline == events[j].kline {
+				t.Fatalf("events %d and %d share a *Kline", i, j)
+			}
+		}
+	}
+	events[1].kline.Close = 100
+	if events[2].kline.Close != 2 {
+		t.Fatal("closed event aliases the last open update")
+	}
+}
@@ -0,0 +1,128 @@
+package huobi
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// Logger 分级日志接口
+// kv为交替出现的键值对, 如 logger.Info("connected", "endpoint", endpoint)
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, kv ...interface{}) {}
+func (nopLogger) Info(msg string, kv ...interface{})  {}
+func (nopLogger) Warn(msg string, kv ...interface{})  {}
+func (nopLogger) Error(msg string, kv ...interface{}) {}
+
+// NopLogger 不输出任何日志, 未设置Logger时默认使用
+var NopLogger Logger = nopLogger{}
+
+// slogLogger log/slog适配器
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger 将*slog.Logger适配为Logger
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l: l}
+}
+
+func (s *slogLogger) Debug(msg string, kv ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelDebug, msg, kv...)
+}
+
+func (s *slogLogger) Info(msg string, kv ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelInfo, msg, kv...)
+}
+
+func (s *slogLogger) Warn(msg string, kv ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelWarn, msg, kv...)
+}
+
+func (s *slogLogger) Error(msg string, kv ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelError, msg, kv...)
+}
+
+// redactedValue 脱敏后的取值
+const redactedValue = "[REDACTED]"
+
+// sensitiveKeys 需要脱敏的字段名, 比较前统一转小写并去掉'-'和'_'
+var sensitiveKeys = map[string]bool{
+	"accesskey":        true,
+	"accesskeyid":      true,
+	"secretkey":        true,
+	"secret":           true,
+	"privatekey":       true,
+	"signature":        true,
+	"privatesignature": true,
+	"passphrase":       true,
+}
+
+// isSensitiveKey 判断字段是否需要脱敏
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	key = strings.Replace(key, "-", "", -1)
+	key = strings.Replace(key, "_", "", -1)
+	return sensitiveKeys[key]
+}
+
+// redactLogger 输出前对敏感字段脱敏
+type redactLogger struct {
+	l Logger
+}
+
+// newLogger 包装用户传入的Logger, nil时返回NopLogger
+func newLogger(l Logger) Logger {
+	if l == nil {
+		return NopLogger
+	}
+	if _, ok := l.(*redactLogger); ok {
+		return l
+	}
+	if _, ok := l.(nopLogger); ok {
+		return l
+	}
+	return &redactLogger{l: l}
+}
+
+func (r *redactLogger) Debug(msg string, kv ...interface{}) { r.l.Debug(msg, redact(kv)...) }
+func (r *redactLogger) Info(msg string, kv ...interface{})  { r.l.Info(msg, redact(kv)...) }
+func (r *redactLogger) Warn(msg string, kv ...interface{})  { r.l.Warn(msg, redact(kv)...) }
+func (r *redactLogger) Error(msg string, kv ...interface{}) { r.l.Error(msg, redact(kv)...) }
+
+// redact 替换键值对中的敏感值, 请求参数这类map[string]string也会逐项处理
+func redact(kv []interface{}) []interface{} {
+	out := make([]interface{}, len(kv))
+	copy(out, kv)
+
+	for i := 0; i+1 < len(out); i += 2 {
+		key, ok := out[i].(string)
+		if !ok {
+			continue
+		}
+		if isSensitiveKey(key) {
+			out[i+1] = redactedValue
+			continue
+		}
+		if params, ok := out[i+1].(map[string]string); ok {
+			redacted := make(map[string]string, len(params))
+			for k, v := range params {
+				if isSensitiveKey(k) {
+					v = redactedValue
+				}
+				redacted[k] = v
+			}
+			out[i+1] = redacted
+		}
+	}
+
+	return out
+}
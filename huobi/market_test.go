@@ -0,0 +1,163 @@
+package huobi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leek-box/sheep/huobi"
+	"github.com/leek-box/sheep/huobi/huobitest"
+)
+
+func TestMarketReconnectResubscribe(t *testing.T) {
+	srv := huobitest.NewServer()
+	defer srv.Close()
+	srv.SetPingInterval(50 * time.Millisecond)
+
+	m, err := huobi.NewMarket(huobi.WithEndpoints(srv.URL), huobi.WithProxy(nil),
+		huobi.WithReconnectBackoff(huobi.Backoff{Min: 10 * time.Millisecond, Max: 50 * time.Millisecond, Factor: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.HeartbeatInterval = 100 * time.Millisecond
+
+	errs := make(chan error, 16)
+	m.OnStateChange(func(state huobi.ConnState, err error) {
+		if state == huobi.StateDisconnected {
+			errs <- err
+		}
+	})
+	go m.Loop()
+	defer m.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const topic = "market.btcusdt.bbo"
+	got := make(chan string, 16)
+	if _, err := m.ListenRaw(topic, func(topic string, msg []byte) {
+		got <- topic
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 推送能送达, 说明订阅在当前连接上有效
+	receive := func(step string) {
+		t.Helper()
+		for {
+			srv.Push(topic, map[string]float64{"ask": 1, "bid": 1})
+			select {
+			case <-got:
+				return
+			case <-time.After(50 * time.Millisecond):
+			case <-ctx.Done():
+				t.Fatalf("%s: no push received", step)
+			}
+		}
+	}
+	receive("initial")
+
+	// 服务器直接断开连接
+	srv.Disconnect()
+	if err := srv.WaitAccepted(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.WaitSubscribed(ctx, topic); err != nil {
+		t.Fatal(err)
+	}
+	receive("after disconnect")
+
+	// 服务器不再发送ping, 客户端应判定心跳超时并重连
+	drainErrors(errs)
+	srv.DropPings(true)
+	select {
+	case err := <-errs:
+		if err != huobi.HeartbeatTimeoutError {
+			t.Fatalf("disconnected with %v, want %v", err, huobi.HeartbeatTimeoutError)
+		}
+	case <-ctx.Done():
+		t.Fatal("heartbeat timeout not detected")
+	}
+	srv.DropPings(false)
+	if err := srv.WaitAccepted(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.WaitSubscribed(ctx, topic); err != nil {
+		t.Fatal(err)
+	}
+	receive("after heartbeat timeout")
+}
+
+func drainErrors(c chan error) {
+	for {
+		select {
+		case <-c:
+		default:
+			return
+		}
+	}
+}
+
+// stateEvent 一次状态变化
+type stateEvent struct {
+	state huobi.ConnState
+	err   error
+}
+
+func TestMarketStateTransitions(t *testing.T) {
+	srv := huobitest.NewServer()
+	defer srv.Close()
+
+	m, err := huobi.NewMarket(huobi.WithEndpoints(srv.URL), huobi.WithProxy(nil),
+		huobi.WithReconnectBackoff(huobi.Backoff{Min: 10 * time.Millisecond, Max: 50 * time.Millisecond, Factor: 2, MaxAttempts: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan stateEvent, 16)
+	m.OnStateChange(func(state huobi.ConnState, err error) {
+		events <- stateEvent{state, err}
+	})
+	loopDone := make(chan struct{})
+	go func() {
+		m.Loop()
+		close(loopDone)
+	}()
+	defer m.Close()
+
+	expect := func(step string, want ...huobi.ConnState) stateEvent {
+		t.Helper()
+		var last stateEvent
+		for i, state := range want {
+			select {
+			case last = <-events:
+				if last.state != state {
+					t.Fatalf("%s: transition %d is %v, want %v", step, i, last.state, state)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: transition %d to %v not received", step, i, state)
+			}
+		}
+		return last
+	}
+
+	srv.Disconnect()
+	expect("disconnect", huobi.StateDisconnected, huobi.StateConnecting, huobi.StateResubscribing, huobi.StateConnected)
+
+	// 重连次数用尽后关闭, Loop返回
+	srv.RejectConnections(true)
+	srv.Disconnect()
+	last := expect("reconnect exhausted", huobi.StateDisconnected, huobi.StateConnecting, huobi.StateClosed)
+	if last.err != huobi.ReconnectExhaustedError {
+		t.Fatalf("closed with %v, want %v", last.err, huobi.ReconnectExhaustedError)
+	}
+	select {
+	case <-loopDone:
+	case <-time.After(time.Second):
+		t.Fatal("Loop still running after the market closed")
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected transition to %v after closed", e.state)
+	default:
+	}
+}
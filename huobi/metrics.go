@@ -0,0 +1,252 @@
+package huobi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics 运行指标采集接口
+type Metrics interface {
+	// ObserveRequest REST请求耗时, errCode为空表示成功
+	ObserveRequest(endpoint, errCode string, latency time.Duration)
+	// IncReconnect websocket重连次数
+	IncReconnect()
+	// ObservePingRTT 心跳往返时间
+	ObservePingRTT(rtt time.Duration)
+	// ObserveMessage 收到的订阅消息, bytes为解压后的大小
+	ObserveMessage(topic string, bytes int)
+	// IncDecodeFailure 消息解析失败
+	IncDecodeFailure(topic string)
+	// SetSubscribed 订阅状态变化
+	SetSubscribed(topic string, subscribed bool)
+	// IncStreamDrop 流的缓冲区满导致丢弃消息
+	IncStreamDrop(topic string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveRequest(endpoint, errCode string, latency time.Duration) {}
+func (nopMetrics) IncReconnect()                                                  {}
+func (nopMetrics) ObservePingRTT(rtt time.Duration)                               {}
+func (nopMetrics) ObserveMessage(topic string, bytes int)                         {}
+func (nopMetrics) IncDecodeFailure(topic string)                                  {}
+func (nopMetrics) SetSubscribed(topic string, subscribed bool)                    {}
+func (nopMetrics) IncStreamDrop(topic string)                                     {}
+
+// NopMetrics 不采集任何指标, 未设置Metrics时默认使用
+var NopMetrics Metrics = nopMetrics{}
+
+// metricsEndpoint 将REST路径中的订单ID等数字段替换为占位符, 避免指标维度膨胀
+func metricsEndpoint(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		if strings.Trim(part, "0123456789") == "" {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+type requestKey struct {
+	endpoint string
+	errCode  string
+}
+
+type durationSummary struct {
+	sum   float64
+	count uint64
+}
+
+func (s *durationSummary) observe(d time.Duration) {
+	s.sum += d.Seconds()
+	s.count++
+}
+
+// PrometheusMetrics 在内存中累计指标, 并以Prometheus文本格式输出
+// 实现了http.Handler, 可以直接挂到本地HTTP服务上, 如 http.Handle("/metrics", metrics)
+type PrometheusMetrics struct {
+	requests        map[requestKey]uint64
+	requestDuration map[string]*durationSummary
+	reconnects      uint64
+	pingRTT         durationSummary
+	lastPingRTT     float64
+	messages        map[string]uint64
+	bytes           map[string]uint64
+	decodeFailures  map[string]uint64
+	subscribed      map[string]bool
+	streamDrops     map[string]uint64
+
+	mutex sync.Mutex
+}
+
+// NewPrometheusMetrics 创建PrometheusMetrics
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		requests:        make(map[requestKey]uint64),
+		requestDuration: make(map[string]*durationSummary),
+		messages:        make(map[string]uint64),
+		bytes:           make(map[string]uint64),
+		decodeFailures:  make(map[string]uint64),
+		subscribed:      make(map[string]bool),
+		streamDrops:     make(map[string]uint64),
+	}
+}
+
+func (p *PrometheusMetrics) ObserveRequest(endpoint, errCode string, latency time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.requests[requestKey{endpoint: endpoint, errCode: errCode}]++
+	summary, ok := p.requestDuration[endpoint]
+	if !ok {
+		summary = &durationSummary{}
+		p.requestDuration[endpoint] = summary
+	}
+	summary.observe(latency)
+}
+
+func (p *PrometheusMetrics) IncReconnect() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.reconnects++
+}
+
+func (p *PrometheusMetrics) ObservePingRTT(rtt time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.pingRTT.observe(rtt)
+	p.lastPingRTT = rtt.Seconds()
+}
+
+func (p *PrometheusMetrics) ObserveMessage(topic string, bytes int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.messages[topic]++
+	p.bytes[topic] += uint64(bytes)
+}
+
+func (p *PrometheusMetrics) IncDecodeFailure(topic string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.decodeFailures[topic]++
+}
+
+func (p *PrometheusMetrics) SetSubscribed(topic string, subscribed bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.subscribed[topic] = subscribed
+}
+
+func (p *PrometheusMetrics) IncStreamDrop(topic string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.streamDrops[topic]++
+}
+
+// ServeHTTP 输出Prometheus文本格式的指标
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+// WriteTo 将指标以Prometheus文本格式写入w
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var b strings.Builder
+
+	writeHeader(&b, "huobi_rest_requests_total", "counter", "REST请求次数, err_code为空表示成功")
+	requestKeys := make([]requestKey, 0, len(p.requests))
+	for k := range p.requests {
+		requestKeys = append(requestKeys, k)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		if requestKeys[i].endpoint != requestKeys[j].endpoint {
+			return requestKeys[i].endpoint < requestKeys[j].endpoint
+		}
+		return requestKeys[i].errCode < requestKeys[j].errCode
+	})
+	for _, k := range requestKeys {
+		fmt.Fprintf(&b, "huobi_rest_requests_total{endpoint=\"%s\",err_code=\"%s\"} %d\n", escapeLabel(k.endpoint), escapeLabel(k.errCode), p.requests[k])
+	}
+
+	writeHeader(&b, "huobi_rest_request_duration_seconds", "summary", "REST请求耗时")
+	endpoints := make([]string, 0, len(p.requestDuration))
+	for endpoint := range p.requestDuration {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		s := p.requestDuration[endpoint]
+		fmt.Fprintf(&b, "huobi_rest_request_duration_seconds_sum{endpoint=\"%s\"} %g\n", escapeLabel(endpoint), s.sum)
+		fmt.Fprintf(&b, "huobi_rest_request_duration_seconds_count{endpoint=\"%s\"} %d\n", escapeLabel(endpoint), s.count)
+	}
+
+	writeHeader(&b, "huobi_ws_reconnects_total", "counter", "websocket重连次数")
+	fmt.Fprintf(&b, "huobi_ws_reconnects_total %d\n", p.reconnects)
+
+	writeHeader(&b, "huobi_ws_ping_rtt_seconds", "summary", "websocket心跳往返时间")
+	fmt.Fprintf(&b, "huobi_ws_ping_rtt_seconds_sum %g\n", p.pingRTT.sum)
+	fmt.Fprintf(&b, "huobi_ws_ping_rtt_seconds_count %d\n", p.pingRTT.count)
+
+	writeHeader(&b, "huobi_ws_last_ping_rtt_seconds", "gauge", "最近一次websocket心跳往返时间")
+	fmt.Fprintf(&b, "huobi_ws_last_ping_rtt_seconds %g\n", p.lastPingRTT)
+
+	writeCounters(&b, "huobi_ws_messages_total", "按主题统计的订阅消息数", p.messages)
+	writeCounters(&b, "huobi_ws_bytes_total", "按主题统计的订阅消息字节数(解压后)", p.bytes)
+	writeCounters(&b, "huobi_ws_decode_failures_total", "按主题统计的消息解析失败次数", p.decodeFailures)
+	writeCounters(&b, "huobi_stream_dropped_total", "按主题统计的流缓冲区丢弃消息数", p.streamDrops)
+
+	writeHeader(&b, "huobi_ws_subscribed", "gauge", "订阅状态, 1为已订阅")
+	topics := make([]string, 0, len(p.subscribed))
+	for topic := range p.subscribed {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		state := 0
+		if p.subscribed[topic] {
+			state = 1
+		}
+		fmt.Fprintf(&b, "huobi_ws_subscribed{topic=\"%s\"} %d\n", escapeLabel(topic), state)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeHeader(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeCounters(b *strings.Builder, name, help string, values map[string]uint64) {
+	writeHeader(b, name, "counter", help)
+	for _, topic := range sortedKeys(values) {
+		fmt.Fprintf(b, "%s{topic=\"%s\"} %d\n", name, escapeLabel(topic), values[topic])
+	}
+}
+
+// escapeLabel 按Prometheus文本格式转义标签值
+func escapeLabel(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	return strings.Replace(v, "\n", `\n`, -1)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
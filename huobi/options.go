@@ -0,0 +1,156 @@
+package huobi
+
+import (
+	"strings"
+	"time"
+)
+
+// options Huobi和Market的创建选项
+type options struct {
+	logger          Logger
+	metrics         Metrics
+	restMiddlewares []RestMiddleware
+
+	topicsPerConnection int
+	maxConnections      int
+
+	backoff Backoff
+
+	endpoints        []string
+	endpointFailover int
+	probeEndpoints   bool
+	privateEndpoint  string
+
+	network  network
+	restHost string
+
+	backfillInterval time.Duration
+}
+
+// Option 创建选项
+type Option func(o *options)
+
+// newOptions 应用选项并填充默认值
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.logger = newLogger(o.logger)
+	if o.metrics == nil {
+		o.metrics = NopMetrics
+	}
+	if o.topicsPerConnection <= 0 {
+		o.topicsPerConnection = defaultTopicsPerConnection
+	}
+	if o.maxConnections <= 0 {
+		o.maxConnections = defaultMaxConnections
+	}
+	if o.backoff == (Backoff{}) {
+		o.backoff = DefaultBackoff
+	}
+	if len(o.endpoints) == 0 {
+		o.endpoints = []string{Endpoint}
+	}
+	o.endpoints = sameProtocol(o.endpoints, o.logger)
+	if o.privateEndpoint == "" {
+		o.privateEndpoint = PrivateEndpointDefault
+	}
+	if o.endpointFailover <= 0 {
+		o.endpointFailover = DefaultEndpointFailover
+	}
+	if o.restHost == "" {
+		o.restHost = DefaultRestHost
+	}
+	if o.backfillInterval <= 0 {
+		o.backfillInterval = DefaultBackfillInterval
+	}
+	return o
+}
+
+// WithLogger 设置日志输出, 默认不输出
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithMetrics 设置指标采集, 默认不采集
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithRestMiddleware 为REST请求添加中间件, 在创建时查询账户的请求也会经过这些中间件
+func WithRestMiddleware(middlewares ...RestMiddleware) Option {
+	return func(o *options) {
+		o.restMiddlewares = append(o.restMiddlewares, middlewares...)
+	}
+}
+
+// WithTopicsPerConnection 设置MarketPool中每个连接最多订阅的主题数, 默认100
+func WithTopicsPerConnection(n int) Option {
+	return func(o *options) {
+		o.topicsPerConnection = n
+	}
+}
+
+// WithMaxConnections 设置MarketPool最多建立的连接数, 默认10
+func WithMaxConnections(n int) Option {
+	return func(o *options) {
+		o.maxConnections = n
+	}
+}
+
+// WithReconnectBackoff 设置断线重连的退避策略, 默认DefaultBackoff
+func WithReconnectBackoff(b Backoff) Option {
+	return func(o *options) {
+		o.backoff = b
+	}
+}
+
+// WithEndpoints 设置行情Websocket的入口, 按顺序使用, 当前入口连续连接失败后切换到下一个
+// 只使用与第一个入口路径相同的入口, 如EndpointDefault和EndpointAWS, 其余的被忽略; 默认只使用Endpoint
+func WithEndpoints(endpoints ...string) Option {
+	return func(o *options) {
+		o.endpoints = append([]string(nil), endpoints...)
+	}
+}
+
+// WithPrivateEndpoint 设置资产和订单Websocket(v2)的入口, 默认PrivateEndpointDefault
+// 鉴权签名使用其中的主机名和路径
+func WithPrivateEndpoint(endpoint string) Option {
+	return func(o *options) {
+		o.privateEndpoint = endpoint
+	}
+}
+
+// WithEndpointFailover 设置连续连接失败多少次后切换入口, 默认DefaultEndpointFailover
+func WithEndpointFailover(n int) Option {
+	return func(o *options) {
+		o.endpointFailover = n
+	}
+}
+
+// WithEndpointProbe 创建时探测所有入口, 按建立连接的耗时重新排序, 优先使用最快的入口
+func WithEndpointProbe() Option {
+	return func(o *options) {
+		o.probeEndpoints = true
+	}
+}
+
+// WithRestHost 设置REST接口地址, 如https://api-aws.huobi.pro, 默认DefaultRestHost
+// 签名使用其中的主机名
+func WithRestHost(baseURL string) Option {
+	return func(o *options) {
+		o.restHost = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithBackfillInterval 设置BackfillKline两次请求之间的最小间隔, 默认DefaultBackfillInterval
+func WithBackfillInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.backfillInterval = d
+	}
+}
@@ -0,0 +1,532 @@
+package huobi
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OrderState 订单状态
+type OrderState string
+
+const (
+	OrderSubmitted       OrderState = "submitted"
+	OrderPartialFilled   OrderState = "partial-filled"
+	OrderFilled          OrderState = "filled"
+	OrderPartialCanceled OrderState = "partial-canceled"
+	OrderCanceled        OrderState = "canceled"
+	OrderRejected        OrderState = "rejected"
+)
+
+// Final 是否为终结状态, 终结后不再变化
+func (s OrderState) Final() bool {
+	switch s {
+	case OrderFilled, OrderPartialCanceled, OrderCanceled, OrderRejected:
+		return true
+	}
+	return false
+}
+
+// rank 状态在订单生命周期中的先后, 未知状态为-1, 用于忽略倒退的状态
+func (s OrderState) rank() int {
+	switch {
+	case s == OrderSubmitted:
+		return 0
+	case s == OrderPartialFilled:
+		return 1
+	case s.Final():
+		return 2
+	}
+	return -1
+}
+
+// parseOrderState 将接口返回的订单状态映射到本地状态
+// created是尚未进入撮合的新订单, 视为submitted; canceling是撤单处理中, 之后仍可能成交或撤销失败, 不改变状态
+func parseOrderState(state string) OrderState {
+	switch state {
+	case "created":
+		return OrderSubmitted
+	case "canceling":
+		return ""
+	}
+	return OrderState(state)
+}
+
+// TrackedOrder OrderManager在本地跟踪的订单
+type TrackedOrder struct {
+	ID     string // 下单被拒绝时为空
+	Symbol string
+	Type   string // buy-limit, sell-limit, buy-market, sell-market
+	Price  float64
+	Amount float64
+	Filled float64 // 累计成交数量
+	State  OrderState
+	ErrMsg string // 被拒绝的原因
+
+	Created time.Time
+	Updated time.Time
+}
+
+// OrderEventType 订单事件类型
+type OrderEventType int
+
+const (
+	// OrderEventFill 订单有新的成交
+	OrderEventFill OrderEventType = iota
+	// OrderEventCancel 订单已撤销, 包括部分成交后撤销
+	OrderEventCancel
+	// OrderEventReject 订单被拒绝, 包括下单请求失败
+	OrderEventReject
+)
+
+func (t OrderEventType) String() string {
+	switch t {
+	case OrderEventFill:
+		return "fill"
+	case OrderEventCancel:
+		return "cancel"
+	case OrderEventReject:
+		return "reject"
+	}
+	return "unknown"
+}
+
+// OrderEvent 订单事件
+type OrderEvent struct {
+	Type  OrderEventType
+	Order TrackedOrder // 事件发生后的订单快照
+
+	// 成交事件中本次成交的数量及价格, 轮询时无法得到成交价, 使用委托价
+	FillVolume float64
+	FillPrice  float64
+
+	// 拒绝事件的原因
+	Err error
+}
+
+// OrderEventListener 订单事件监听器
+type OrderEventListener = func(event *OrderEvent)
+
+// orderUpdate 来自轮询或推送的订单状态
+type orderUpdate struct {
+	state OrderState
+	// 累计成交数量, 小于0表示未知, 此时使用volume累加
+	filled float64
+	volume float64
+	price  float64
+	errMsg string
+}
+
+// earlyUpdate 早于下单结果到达的推送
+type earlyUpdate struct {
+	update   orderUpdate
+	received time.Time
+}
+
+// earlyUpdateTTL 早到推送的保留时间, 超过后认为不是通过Huobi下的订单
+const earlyUpdateTTL = time.Minute
+
+// defaultOrderEventBuffer 订单事件流的默认缓冲大小, 订单事件不多但丢失代价大
+const defaultOrderEventBuffer = 1024
+
+// OrderManager 记录通过Huobi下的所有订单, 并跟踪其状态直到终结
+// 状态来自私有Websocket的订单推送, 推送不可用或断开时轮询REST接口
+type OrderManager struct {
+	h *Huobi
+
+	// PollInterval 轮询未终结订单的间隔, 默认2秒
+	PollInterval time.Duration
+
+	orders map[string]*TrackedOrder
+	early  map[string][]earlyUpdate
+
+	fillListeners   []OrderEventListener
+	cancelListeners []OrderEventListener
+	rejectListeners []OrderEventListener
+	streams         map[*streamBuffer]bool
+
+	// 私有推送只订阅一次, 多次调用Run不会重复添加监听器
+	subscribeOnce sync.Once
+	// 私有推送是否可用
+	pushing bool
+	// 私有推送重连后需要轮询一次, 补上断开期间的变化
+	resync bool
+
+	// 待分发的事件, 按状态变化的顺序排列
+	queue []*OrderEvent
+	// 分发协程是否在运行
+	dispatching bool
+
+	mutex sync.Mutex
+
+	logger  Logger
+	metrics Metrics
+}
+
+func newOrderManager(h *Huobi) *OrderManager {
+	return &OrderManager{
+		h:            h,
+		PollInterval: 2 * time.Second,
+		orders:       make(map[string]*TrackedOrder),
+		early:        make(map[string][]earlyUpdate),
+		streams:      make(map[*streamBuffer]bool),
+		logger:       h.logger,
+		metrics:      h.metrics,
+	}
+}
+
+// Orders 返回订单管理器, 通过Place下的订单都会被记录
+// 需要调用OrderManager.Run才会跟踪订单状态
+func (h *Huobi) Orders() *OrderManager {
+	return h.orders
+}
+
+// OnFill 添加成交事件监听器
+func (m *OrderManager) OnFill(listener OrderEventListener) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.fillListeners = append(m.fillListeners, listener)
+}
+
+// OnCancel 添加撤销事件监听器
+func (m *OrderManager) OnCancel(listener OrderEventListener) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.cancelListeners = append(m.cancelListeners, listener)
+}
+
+// OnReject 添加拒绝事件监听器
+func (m *OrderManager) OnReject(listener OrderEventListener) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rejectListeners = append(m.rejectListeners, listener)
+}
+
+// Events 以通道形式接收所有订单事件, ctx结束后关闭通道
+// 事件在OrderManager的分发协程中写入, 默认缓冲1024条并在满时丢弃最旧的事件
+// 指定OverflowBlock时不丢弃事件, 慢的消费者会推迟所有监听器和事件流收到之后的事件, 但不阻塞订单推送和下单
+func (m *OrderManager) Events(ctx context.Context, config ...StreamConfig) <-chan *OrderEvent {
+	if len(config) == 0 {
+		config = []StreamConfig{{Buffer: defaultOrderEventBuffer, Policy: OverflowDropOldest}}
+	}
+	buf := newStreamBuffer("orders", m.metrics, config)
+	m.mutex.Lock()
+	m.streams[buf] = true
+	m.mutex.Unlock()
+
+	out := make(chan *OrderEvent)
+	runStream(ctx, func() {
+		m.mutex.Lock()
+		delete(m.streams, buf)
+		m.mutex.Unlock()
+	}, nil, buf, func(ctx context.Context, v interface{}) bool {
+		select {
+		case out <- v.(*OrderEvent):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() { close(out) })
+
+	return out
+}
+
+// Order 查询本地记录的订单
+func (m *OrderManager) Order(id string) (TrackedOrder, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	o, ok := m.orders[id]
+	if !ok {
+		return TrackedOrder{}, false
+	}
+	return *o, true
+}
+
+// OpenOrders 未终结的订单, symbol为空时返回所有交易对, 按下单时间排序
+func (m *OrderManager) OpenOrders(symbol string) []TrackedOrder {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var orders []TrackedOrder
+	for _, o := range m.orders {
+		if !o.State.Final() && (symbol == "" || o.Symbol == symbol) {
+			orders = append(orders, *o)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].Created.Before(orders[j].Created)
+	})
+	return orders
+}
+
+// Forget 不再跟踪已终结的订单, 释放内存
+func (m *OrderManager) Forget(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if o, ok := m.orders[id]; ok && o.State.Final() {
+		delete(m.orders, id)
+	}
+}
+
+// track 记录下单成功的订单, 并补上早于下单结果到达的推送
+func (m *OrderManager) track(id, symbol, typ string, price, amount float64) {
+	now := time.Now()
+	m.mutex.Lock()
+	m.orders[id] = &TrackedOrder{
+		ID:      id,
+		Symbol:  symbol,
+		Type:    typ,
+		Price:   price,
+		Amount:  amount,
+		State:   OrderSubmitted,
+		Created: now,
+		Updated: now,
+	}
+	for _, e := range m.early[id] {
+		m.apply(id, e.update)
+	}
+	delete(m.early, id)
+	m.mutex.Unlock()
+
+	m.dispatch()
+}
+
+// reject 记录下单请求失败, 发出拒绝事件
+func (m *OrderManager) reject(symbol, typ string, price, amount float64, err error) {
+	now := time.Now()
+	o := TrackedOrder{
+		Symbol:  symbol,
+		Type:    typ,
+		Price:   price,
+		Amount:  amount,
+		State:   OrderRejected,
+		ErrMsg:  err.Error(),
+		Created: now,
+		Updated: now,
+	}
+
+	m.mutex.Lock()
+	m.queue = append(m.queue, &OrderEvent{Type: OrderEventReject, Order: o, Err: err})
+	m.mutex.Unlock()
+
+	m.dispatch()
+}
+
+// update 处理一次订单状态, 订单尚未记录时暂存
+func (m *OrderManager) update(id string, u orderUpdate) {
+	m.mutex.Lock()
+	if _, ok := m.orders[id]; ok {
+		m.apply(id, u)
+	} else {
+		m.early[id] = append(m.early[id], earlyUpdate{update: u, received: time.Now()})
+	}
+	m.mutex.Unlock()
+
+	m.dispatch()
+}
+
+// apply 更新订单并将产生的事件放入队列, 调用方需持有m.mutex
+// 终结后的订单、倒退的状态及成交数量会被忽略, 轮询结果晚于推送时不会产生重复事件
+func (m *OrderManager) apply(id string, u orderUpdate) {
+	o, ok := m.orders[id]
+	if !ok || o.State.Final() {
+		return
+	}
+
+	filled := u.filled
+	if filled < 0 {
+		filled = o.Filled + u.volume
+	}
+	var volume float64
+	if filled > o.Filled {
+		volume = filled - o.Filled
+		o.Filled = filled
+	}
+	if u.state.rank() > o.State.rank() {
+		o.State = u.state
+	}
+	if o.Filled > 0 && o.State == OrderSubmitted {
+		o.State = OrderPartialFilled
+	}
+	if u.errMsg != "" {
+		o.ErrMsg = u.errMsg
+	}
+	o.Updated = time.Now()
+	snapshot := *o
+
+	if volume > 0 {
+		price := u.price
+		if price == 0 {
+			price = snapshot.Price
+		}
+		m.queue = append(m.queue, &OrderEvent{Type: OrderEventFill, Order: snapshot, FillVolume: volume, FillPrice: price})
+	}
+	switch snapshot.State {
+	case OrderCanceled, OrderPartialCanceled:
+		m.queue = append(m.queue, &OrderEvent{Type: OrderEventCancel, Order: snapshot})
+	case OrderRejected:
+		m.queue = append(m.queue, &OrderEvent{Type: OrderEventReject, Order: snapshot, Err: errors.New(snapshot.ErrMsg)})
+	}
+}
+
+// dispatch 队列中有事件时启动分发协程, 监听器不在Place或推送的协程中调用
+// 同一时间只有一个分发协程, 队列清空后退出
+func (m *OrderManager) dispatch() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.dispatching || len(m.queue) == 0 {
+		return
+	}
+	m.dispatching = true
+	go m.drain()
+}
+
+// drain 按顺序将队列中的事件交给监听器及所有事件流
+// 监听器中可以再下单, 产生的事件在当前事件之后分发
+func (m *OrderManager) drain() {
+	m.mutex.Lock()
+	for len(m.queue) > 0 {
+		event := m.queue[0]
+		m.queue[0] = nil
+		m.queue = m.queue[1:]
+
+		var listeners []OrderEventListener
+		switch event.Type {
+		case OrderEventFill:
+			listeners = append(listeners, m.fillListeners...)
+		case OrderEventCancel:
+			listeners = append(listeners, m.cancelListeners...)
+		case OrderEventReject:
+			listeners = append(listeners, m.rejectListeners...)
+		}
+		streams := make([]*streamBuffer, 0, len(m.streams))
+		for buf := range m.streams {
+			streams = append(streams, buf)
+		}
+		m.mutex.Unlock()
+
+		m.logger.Info("order event", "type", event.Type.String(), "order-id", event.Order.ID, "state", string(event.Order.State))
+		for _, listener := range listeners {
+			listener(event)
+		}
+		for _, buf := range streams {
+			buf.push(event)
+		}
+
+		m.mutex.Lock()
+	}
+
+	m.dispatching = false
+	m.mutex.Unlock()
+}
+
+// pruneEarly 清理过期的早到推送, 私有推送包含账户下所有订单, 不是通过Huobi下的订单会一直留在这里
+// 调用方需持有m.mutex
+func (m *OrderManager) pruneEarly() {
+	for id, updates := range m.early {
+		if time.Since(updates[len(updates)-1].received) > earlyUpdateTTL {
+			delete(m.early, id)
+		}
+	}
+}
+
+// handleOrderUpdate 处理私有Websocket的订单推送
+func (m *OrderManager) handleOrderUpdate(u *OrderUpdate) {
+	update := orderUpdate{
+		state:  parseOrderState(u.OrderStatus),
+		filled: -1,
+		errMsg: u.ErrMessage,
+	}
+	if u.ExecAmt != "" {
+		update.filled, _ = strconv.ParseFloat(u.ExecAmt, 64)
+	} else if u.EventType == "trade" {
+		update.volume, _ = strconv.ParseFloat(u.TradeVolume, 64)
+	}
+	if u.EventType == "trade" {
+		update.price, _ = strconv.ParseFloat(u.TradePrice, 64)
+	}
+	m.update(strconv.FormatInt(u.OrderID, 10), update)
+}
+
+// poll 查询所有未终结订单的状态
+func (m *OrderManager) poll() {
+	m.mutex.Lock()
+	ids := make([]string, 0, len(m.orders))
+	for id, o := range m.orders {
+		if !o.State.Final() {
+			ids = append(ids, id)
+		}
+	}
+	m.mutex.Unlock()
+
+	for _, id := range ids {
+		if err := m.refresh(id); err != nil {
+			m.logger.Warn("poll order failed", "order-id", id, "err", err)
+		}
+	}
+}
+
+// refresh 通过REST接口查询单个订单的状态
+func (m *OrderManager) refresh(id string) error {
+	order, err := m.h.GetOrderInfo(id)
+	if err != nil {
+		return err
+	}
+	filled, _ := strconv.ParseFloat(order.FieldAmount, 64)
+	m.update(id, orderUpdate{state: parseOrderState(order.State), filled: filled})
+	return nil
+}
+
+// subscribePrivate 订阅私有Websocket的订单推送, 失败时只使用轮询
+func (m *OrderManager) subscribePrivate() {
+	p, err := m.h.Private()
+	if err != nil {
+		m.logger.Warn("order manager falls back to polling", "err", err)
+		return
+	}
+
+	p.OnStateChange(func(state ConnState, err error) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if state == StateConnected && !m.pushing {
+			m.resync = true
+		}
+		m.pushing = state == StateConnected
+	})
+	if err := p.SubscribeOrders("*", m.handleOrderUpdate); err != nil {
+		m.logger.Warn("order manager falls back to polling", "err", err)
+		return
+	}
+
+	m.mutex.Lock()
+	m.pushing = p.State() == StateConnected
+	m.mutex.Unlock()
+}
+
+// Run 跟踪订单状态, 直到ctx结束
+// 优先使用私有Websocket的订单推送, 推送不可用或断开期间每PollInterval轮询一次, 推送恢复后再轮询一次补上变化
+func (m *OrderManager) Run(ctx context.Context) error {
+	m.subscribeOnce.Do(m.subscribePrivate)
+
+	// 启动时同步一次
+	m.poll()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.PollInterval):
+		}
+
+		m.mutex.Lock()
+		needPoll := !m.pushing || m.resync
+		m.resync = false
+		m.pruneEarly()
+		m.mutex.Unlock()
+		if needPoll {
+			m.poll()
+		}
+	}
+}
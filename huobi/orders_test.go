@@ -0,0 +1,155 @@
+package huobi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestOrderManager() *OrderManager {
+	return newOrderManager(&Huobi{logger: NopLogger, metrics: NopMetrics})
+}
+
+// nextEvent 读取一个事件, 超时返回nil
+func nextEvent(events <-chan *OrderEvent) *OrderEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+// noEvent 确认没有更多事件
+func noEvent(t *testing.T, events <-chan *OrderEvent) {
+	t.Helper()
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected %v event, state %s", e.Type, e.Order.State)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOrderEarlyUpdate(t *testing.T) {
+	m := newTestOrderManager()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := m.Events(ctx, StreamConfig{Policy: OverflowBlock})
+
+	// 推送早于下单结果到达, 下单结果到达后补上
+	m.update("1", orderUpdate{state: OrderPartialFilled, filled: 0.5, price: 10})
+	noEvent(t, events)
+	m.track("1", "btcusdt", "buy-limit", 10, 2)
+
+	e := nextEvent(events)
+	if e == nil || e.Type != OrderEventFill || e.FillVolume != 0.5 || e.Order.State != OrderPartialFilled {
+		t.Fatalf("got %+v, want a fill of 0.5", e)
+	}
+	if o, _ := m.Order("1"); o.Filled != 0.5 || o.State != OrderPartialFilled {
+		t.Fatalf("order %+v", o)
+	}
+}
+
+func TestOrderEarlyUpdateExpired(t *testing.T) {
+	m := newTestOrderManager()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := m.Events(ctx, StreamConfig{Policy: OverflowBlock})
+
+	m.update("1", orderUpdate{state: OrderFilled, filled: 2})
+	m.update("2", orderUpdate{state: OrderFilled, filled: 2})
+	m.mutex.Lock()
+	m.early["1"][0].received = time.Now().Add(-earlyUpdateTTL - time.Second)
+	m.pruneEarly()
+	_, expired := m.early["1"]
+	_, kept := m.early["2"]
+	m.mutex.Unlock()
+	if expired || !kept {
+		t.Fatalf("after pruning: expired update kept %v, fresh update kept %v", expired, kept)
+	}
+
+	// 过期的推送不再应用到之后记录的同ID订单上
+	m.track("1", "btcusdt", "buy-limit", 10, 2)
+	noEvent(t, events)
+	if o, _ := m.Order("1"); o.State != OrderSubmitted || o.Filled != 0 {
+		t.Fatalf("order %+v", o)
+	}
+}
+
+func TestOrderStateNeverMovesBackwards(t *testing.T) {
+	m := newTestOrderManager()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := m.Events(ctx, StreamConfig{Policy: OverflowBlock})
+	m.track("1", "btcusdt", "buy-limit", 10, 2)
+
+	m.update("1", orderUpdate{state: parseOrderState("partial-filled"), filled: 1})
+	if e := nextEvent(events); e == nil || e.Type != OrderEventFill || e.FillVolume != 1 {
+		t.Fatalf("got %+v, want a fill of 1", e)
+	}
+
+	// 晚到的轮询结果不能让订单倒退
+	m.update("1", orderUpdate{state: parseOrderState("created"), filled: 0})
+	m.update("1", orderUpdate{state: parseOrderState("submitted"), filled: 0.5})
+	m.update("1", orderUpdate{state: parseOrderState("canceling"), filled: 1})
+	noEvent(t, events)
+	if o, _ := m.Order("1"); o.State != OrderPartialFilled || o.Filled != 1 {
+		t.Fatalf("order moved backwards: %+v", o)
+	}
+
+	m.update("1", orderUpdate{state: parseOrderState("partial-canceled"), filled: 1})
+	if e := nextEvent(events); e == nil || e.Type != OrderEventCancel || e.Order.State != OrderPartialCanceled {
+		t.Fatalf("got %+v, want a cancel", e)
+	}
+	m.update("1", orderUpdate{state: parseOrderState("filled"), filled: 2})
+	noEvent(t, events)
+}
+
+func TestOrderCreatedIsSubmitted(t *testing.T) {
+	if s := parseOrderState("created"); s != OrderSubmitted {
+		t.Fatalf("created maps to %q", s)
+	}
+	if s := parseOrderState("canceling"); s != "" {
+		t.Fatalf("canceling maps to %q", s)
+	}
+}
+
+// 监听器在分发协程中调用, 阻塞的监听器不会阻塞记录订单的调用方
+func TestOrderEventsAsync(t *testing.T) {
+	m := newTestOrderManager()
+	release := make(chan struct{})
+	called := make(chan *OrderEvent, 2)
+	m.OnFill(func(e *OrderEvent) {
+		called <- e
+		<-release
+	})
+
+	done := make(chan struct{})
+	go func() {
+		m.update("1", orderUpdate{filled: 1})
+		m.track("1", "btcusdt", "buy-limit", 10, 2)
+		m.update("1", orderUpdate{filled: 2, state: OrderFilled})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("track blocked by a listener")
+	}
+
+	// 事件按顺序分发
+	for _, want := range []float64{1, 1} {
+		select {
+		case e := <-called:
+			if e.FillVolume != want {
+				t.Fatalf("fill volume %v, want %v", e.FillVolume, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("listener not called")
+		}
+		release <- struct{}{}
+	}
+	if o, _ := m.Order("1"); o.State != OrderFilled {
+		t.Fatalf("order %+v", o)
+	}
+}
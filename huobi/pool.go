@@ -0,0 +1,504 @@
+package huobi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bitly/go-simplejson"
+)
+
+// MarketPool连接数的默认值
+const (
+	defaultTopicsPerConnection = 100
+	defaultMaxConnections      = 10
+)
+
+// MarketPoolFullError 所有连接的主题数都已达到上限
+var MarketPoolFullError = errors.New("market pool is full")
+
+// MarketPool 将主题分散到多个行情Websocket连接上, 接口与Market一致
+// 每个连接最多订阅的主题数由WithTopicsPerConnection设置, 不够时新建连接, 连接数上限由WithMaxConnections设置
+// 某个连接重连后, 超出平均数的主题会迁移到较空闲的连接上
+// 某个连接重连次数用尽后被移出连接池, 其上的订阅句柄收到ReconnectExhaustedError, 其他连接不受影响
+type MarketPool struct {
+	shards []*Market
+	// 主题所在的连接
+	topics map[string]*Market
+	// rebalance迁移主题的次数, 移除订阅时据此判断期间监听器是否被迁移
+	moves uint64
+	// 下一个Request使用的连接
+	next int
+
+	topicsPerConnection int
+	maxConnections      int
+
+	options *options
+	// 状态监听器, 新建的连接也会添加
+	stateListeners []StateListener
+	// Loop运行中新建的连接需要单独启动Loop
+	looping bool
+	// 正在新建连接时不为nil, 新建结束后关闭
+	dialing chan struct{}
+	wg      sync.WaitGroup
+
+	mutex sync.Mutex
+
+	logger Logger
+}
+
+// NewMarketPool 创建MarketPool实例, 并建立第一个连接
+func NewMarketPool(opts ...Option) (*MarketPool, error) {
+	o := newOptions(opts)
+	if o.probeEndpoints {
+		o.endpoints = probeEndpoints(o.endpoints, o.network.websocketDialer(), defaultProbeTimeout, o.logger)
+	}
+	p := &MarketPool{
+		topics:              make(map[string]*Market),
+		topicsPerConnection: o.topicsPerConnection,
+		maxConnections:      o.maxConnections,
+		options:             o,
+		logger:              o.logger,
+	}
+
+	m, err := newMarket(o, p.rebalance)
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	p.addShard(m)
+	p.mutex.Unlock()
+
+	return p, nil
+}
+
+// addShard 加入新建的连接, 调用方需持有p.mutex
+func (p *MarketPool) addShard(m *Market) {
+	for _, listener := range p.stateListeners {
+		m.OnStateChange(listener)
+	}
+	p.shards = append(p.shards, m)
+	p.logger.Info("market pool add connection", "connections", len(p.shards))
+
+	if p.looping {
+		p.wg.Add(1)
+		go p.runShard(m)
+	}
+}
+
+// runShard 运行连接的循环, 调用方需先调用p.wg.Add
+// 连接重连次数用尽而关闭时将其移出连接池
+func (p *MarketPool) runShard(m *Market) {
+	defer p.wg.Done()
+	m.Loop()
+	if !m.supervisor.closed() {
+		p.removeShard(m)
+	}
+}
+
+// removeShard 移除重连次数用尽的连接, 其上的订阅句柄收到ReconnectExhaustedError后关闭
+// 主题的分配随之释放, 之后再订阅会分配到其他连接; 最后一个连接保留在连接池中, 供Request返回错误
+func (p *MarketPool) removeShard(m *Market) {
+	p.mutex.Lock()
+	if len(p.shards) > 1 {
+		for i, shard := range p.shards {
+			if shard == m {
+				p.shards = append(p.shards[:i:i], p.shards[i+1:]...)
+				break
+			}
+		}
+	}
+	var subs []*Subscription
+	for topic, shard := range p.topics {
+		if shard != m {
+			continue
+		}
+		delete(p.topics, topic)
+		listeners, ack := m.detach(topic)
+		if ack != nil {
+			ack.finish(ReconnectExhaustedError, func() {})
+		}
+		for sub := range listeners {
+			subs = append(subs, sub)
+		}
+	}
+	connections := len(p.shards)
+	p.mutex.Unlock()
+
+	p.logger.Error("market pool remove connection", "connections", connections, "subscriptions", len(subs))
+	// 句柄已从连接上移出, 不会再被并发访问
+	for _, sub := range subs {
+		sub.reportError(ReconnectExhaustedError)
+		sub.close()
+	}
+}
+
+// loads 每个连接上分配的主题数, 调用方需持有p.mutex
+func (p *MarketPool) loads() map[*Market]int {
+	loads := make(map[*Market]int, len(p.shards))
+	for _, m := range p.shards {
+		loads[m] = 0
+	}
+	for _, m := range p.topics {
+		loads[m]++
+	}
+	return loads
+}
+
+// shardFor 返回主题所在的连接, 未分配时选择主题最少的连接, 都已满时新建连接
+// assign在确定连接的同一次持锁中调用, 用于添加监听器, 使其不会与rebalance和release交错
+// 新建连接时不持有p.mutex, 握手期间其他连接的读协程不受影响; 同一时间只新建一个连接, 其他调用方等待其结果
+func (p *MarketPool) shardFor(topic string, assign func(m *Market)) (*Market, error) {
+	for {
+		p.mutex.Lock()
+		if m, ok := p.topics[topic]; ok {
+			assign(m)
+			p.mutex.Unlock()
+			return m, nil
+		}
+
+		loads := p.loads()
+		var shard *Market
+		for _, m := range p.shards {
+			if loads[m] < p.topicsPerConnection && (shard == nil || loads[m] < loads[shard]) {
+				shard = m
+			}
+		}
+		if shard != nil {
+			p.topics[topic] = shard
+			assign(shard)
+			p.mutex.Unlock()
+			return shard, nil
+		}
+
+		if dialing := p.dialing; dialing != nil {
+			p.mutex.Unlock()
+			<-dialing
+			continue
+		}
+		if len(p.shards) >= p.maxConnections {
+			p.mutex.Unlock()
+			return nil, MarketPoolFullError
+		}
+		dialing := make(chan struct{})
+		p.dialing = dialing
+		p.mutex.Unlock()
+
+		m, err := newMarket(p.options, p.rebalance)
+
+		p.mutex.Lock()
+		p.dialing = nil
+		close(dialing)
+		if err == nil {
+			p.addShard(m)
+		}
+		p.mutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// release 连接上已没有该主题的监听器时释放主题的分配
+func (p *MarketPool) release(topic string, m *Market) {
+	p.mutex.Lock()
+	if p.topics[topic] == m && !m.hasTopic(topic) {
+		delete(p.topics, topic)
+	}
+	p.mutex.Unlock()
+}
+
+// rebalance 连接重连后, 服务器端已没有该连接的订阅, 将超出平均数的主题迁移到较空闲的连接上
+func (p *MarketPool) rebalance(m *Market) {
+	p.mutex.Lock()
+	if len(p.shards) < 2 {
+		p.mutex.Unlock()
+		return
+	}
+
+	loads := p.loads()
+	target := (len(p.topics) + len(p.shards) - 1) / len(p.shards)
+	moves := make(map[string]*Market)
+	for _, topic := range m.topics() {
+		if loads[m] <= target {
+			break
+		}
+		if p.topics[topic] != m {
+			continue
+		}
+
+		var dst *Market
+		for _, shard := range p.shards {
+			if shard != m && loads[shard] < target && (dst == nil || loads[shard] < loads[dst]) {
+				dst = shard
+			}
+		}
+		if dst == nil {
+			break
+		}
+
+		loads[m]--
+		loads[dst]++
+		moves[topic] = dst
+	}
+
+	pending := p.moveTopics(m, moves)
+	p.mutex.Unlock()
+
+	if len(moves) > 0 {
+		p.logger.Info("market pool rebalance", "topics", len(moves))
+	}
+	// 迁移前未完成的订阅以新连接上的订阅结果为准
+	for topic, dst := range moves {
+		err := dst.subscribe(topic)
+		if err != nil {
+			p.logger.Error("market pool move topic failed", "topic", topic, "err", err)
+			dst.reportError(topic, err)
+		} else if !dst.hasTopic(topic) {
+			// 订阅期间监听器已全部移除
+			dst.Unsubscribe(topic)
+		}
+		if ack := pending[topic]; ack != nil {
+			ack.finish(err, func() {})
+		}
+	}
+}
+
+// moveTopics 将主题的监听器从m迁移到目标连接, 返回迁移前未完成的订阅, 调用方需持有p.mutex
+// 监听器的迁移和p.topics的更新在同一次持锁中完成, 与添加监听器互斥
+// 移除订阅时不持有p.mutex, 可能在旧连接上找不到已迁移的句柄, 通过p.moves发现后到新连接上重试
+// 监听器已全部移除的主题从moves中删除
+func (p *MarketPool) moveTopics(m *Market, moves map[string]*Market) map[string]*subscribeAck {
+	pending := make(map[string]*subscribeAck, len(moves))
+	for topic, dst := range moves {
+		listeners, ack := m.detach(topic)
+		if len(listeners) == 0 {
+			delete(moves, topic)
+			if p.topics[topic] == m {
+				delete(p.topics, topic)
+			}
+			if ack != nil {
+				ack.finish(nil, func() {})
+			}
+			continue
+		}
+		dst.attach(topic, listeners)
+		p.topics[topic] = dst
+		pending[topic] = ack
+	}
+	if len(moves) > 0 {
+		p.moves++
+	}
+	return pending
+}
+
+// Subscribe 订阅, 并替换该主题已有的全部监听器
+func (p *MarketPool) Subscribe(topic string, listener Listener) error {
+	_, err := p.SubscribeAsync(topic, listener).Wait(context.Background())
+	return err
+}
+
+// SubscribeAsync 异步订阅, 并替换该主题已有的全部监听器
+func (p *MarketPool) SubscribeAsync(topic string, listener Listener) *SubscribeFuture {
+	return p.listenAsync(topic, p.jsonListener(listener), true)
+}
+
+// Listen 为主题添加一个监听器, 返回订阅句柄
+func (p *MarketPool) Listen(topic string, listener Listener) (*Subscription, error) {
+	return p.ListenAsync(topic, listener).Wait(context.Background())
+}
+
+// ListenAsync 异步为主题添加一个监听器, 订阅结果通过SubscribeFuture获取
+func (p *MarketPool) ListenAsync(topic string, listener Listener) *SubscribeFuture {
+	return p.listenAsync(topic, p.jsonListener(listener), false)
+}
+
+// ListenRaw 为主题添加一个接收消息原文的监听器, 省去解析为simplejson的开销
+func (p *MarketPool) ListenRaw(topic string, listener RawListener) (*Subscription, error) {
+	return p.ListenRawAsync(topic, listener).Wait(context.Background())
+}
+
+// ListenRawAsync 异步为主题添加一个接收消息原文的监听器
+func (p *MarketPool) ListenRawAsync(topic string, listener RawListener) *SubscribeFuture {
+	return p.listenAsync(topic, listener, false)
+}
+
+// SubscribeMany 同时为多个主题添加监听器, 订阅指令并行发送, 按topics的顺序返回每个主题的结果
+func (p *MarketPool) SubscribeMany(ctx context.Context, topics []string, listener Listener) []SubscribeResult {
+	futures := make([]*SubscribeFuture, len(topics))
+	for i, topic := range topics {
+		futures[i] = p.ListenAsync(topic, listener)
+	}
+	return waitSubscribeFutures(ctx, futures)
+}
+
+// jsonListener 将消息解析为simplejson后交给listener
+func (p *MarketPool) jsonListener(listener Listener) RawListener {
+	return func(topic string, msg []byte) {
+		json, err := simplejson.NewJson(msg)
+		if err != nil {
+			p.logger.Error("parse message failed", "topic", topic, "err", err)
+			p.options.metrics.IncDecodeFailure(topic)
+			p.reportError(topic, err)
+			return
+		}
+		listener(topic, json)
+	}
+}
+
+func (p *MarketPool) listenAsync(topic string, listener RawListener, replace bool) *SubscribeFuture {
+	sub := newSubscription(p, topic)
+	m, err := p.shardFor(topic, func(m *Market) {
+		m.addListener(sub, listener, replace)
+	})
+	if err != nil {
+		return failedSubscribeFuture(topic, err)
+	}
+	return m.awaitSubscribe(sub)
+}
+
+// lookup 返回主题所在的连接和当前的迁移次数
+func (p *MarketPool) lookup(topic string) (*Market, uint64, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	m, ok := p.topics[topic]
+	return m, p.moves, ok
+}
+
+// movedSince 查询后是否发生过迁移
+func (p *MarketPool) movedSince(moves uint64) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.moves != moves
+}
+
+// removeSubscription 移除订阅句柄, 主题没有监听器后取消订阅
+// 等待unsubbed确认时不持有p.mutex, 期间句柄被rebalance迁移时到新连接上重试
+func (p *MarketPool) removeSubscription(sub *Subscription) error {
+	for {
+		m, moves, ok := p.lookup(sub.topic)
+		if !ok {
+			return nil
+		}
+
+		found, err := m.remove(sub)
+		if found {
+			p.release(sub.topic, m)
+			return err
+		}
+		if !p.movedSince(moves) {
+			// 句柄已被移除
+			return nil
+		}
+	}
+}
+
+// Unsubscribe 取消订阅, 该主题的所有订阅句柄都会失效
+// 与removeSubscription一样, 期间监听器被迁移时到新连接上重试
+func (p *MarketPool) Unsubscribe(topic string) error {
+	for {
+		m, moves, ok := p.lookup(topic)
+		if !ok {
+			return nil
+		}
+
+		err := m.Unsubscribe(topic)
+		if !p.movedSince(moves) {
+			p.release(topic, m)
+			return err
+		}
+	}
+}
+
+// reportError 将错误投递给主题的所有订阅句柄
+func (p *MarketPool) reportError(topic string, err error) {
+	p.mutex.Lock()
+	m, ok := p.topics[topic]
+	p.mutex.Unlock()
+	if ok {
+		m.reportError(topic, err)
+	}
+}
+
+// request 轮流选择连接发送请求
+func (p *MarketPool) request() *Market {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	m := p.shards[p.next%len(p.shards)]
+	p.next++
+	return m
+}
+
+// Request 请求行情信息
+func (p *MarketPool) Request(req string) (*simplejson.Json, error) {
+	return p.request().Request(req)
+}
+
+// RequestRange 请求指定时间范围内的行情信息
+func (p *MarketPool) RequestRange(req string, from, to time.Time) (*simplejson.Json, error) {
+	return p.request().RequestRange(req, from, to)
+}
+
+// requestRaw 请求行情信息, 返回消息原文
+func (p *MarketPool) requestRaw(req string) ([]byte, error) {
+	return p.request().request(reqData{Req: req})
+}
+
+// requestRangeRaw 请求指定时间范围内的行情信息, 返回消息原文
+func (p *MarketPool) requestRangeRaw(req string, from, to time.Time) ([]byte, error) {
+	return p.request().request(reqData{Req: req, From: from.Unix(), To: to.Unix()})
+}
+
+// OnStateChange 为所有连接添加状态监听器, 包括之后新建的连接
+// 各连接的状态变化分别通知, 可能在不同的协程中并发调用
+func (p *MarketPool) OnStateChange(listener StateListener) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stateListeners = append(p.stateListeners, listener)
+	for _, m := range p.shards {
+		m.OnStateChange(listener)
+	}
+}
+
+// Loop 进入循环, 所有连接都关闭后返回
+func (p *MarketPool) Loop() {
+	p.mutex.Lock()
+	p.looping = true
+	for _, m := range p.shards {
+		p.wg.Add(1)
+		go p.runShard(m)
+	}
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+}
+
+// ReConnect 重新连接所有连接
+func (p *MarketPool) ReConnect() error {
+	p.mutex.Lock()
+	shards := append([]*Market(nil), p.shards...)
+	p.mutex.Unlock()
+
+	for _, m := range shards {
+		if err := m.ReConnect(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 关闭所有连接
+func (p *MarketPool) Close() error {
+	p.mutex.Lock()
+	shards := append([]*Market(nil), p.shards...)
+	p.mutex.Unlock()
+
+	var err error
+	for _, m := range shards {
+		if e := m.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package huobi
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// bareMarket 不连接服务器的Market, 主题未发送过订阅指令, 移除监听器时不会发送unsub
+func bareMarket() *Market {
+	return &Market{
+		listeners:           make(map[string]map[*Subscription]RawListener),
+		subscribedTopic:     make(map[string]bool),
+		subscribeResultCb:   make(map[string]*subscribeAck),
+		unsubscribeResultCb: make(map[string]replyChan),
+		mutex:               &sync.RWMutex{},
+		logger:              NopLogger,
+		metrics:             NopMetrics,
+	}
+}
+
+// 移除订阅时先查到旧连接, 在旧连接上移除前主题被迁移, 需要到新连接上移除
+func TestMarketPoolRemoveDuringMove(t *testing.T) {
+	const topic = "market.btcusdt.bbo"
+	for _, unsubscribe := range []bool{false, true} {
+		src, dst := bareMarket(), bareMarket()
+		p := &MarketPool{shards: []*Market{src, dst}, topics: make(map[string]*Market), topicsPerConnection: 1, logger: NopLogger}
+		sub := newSubscription(p, topic)
+		if _, err := p.shardFor(topic, func(m *Market) { m.addListener(sub, func(string, []byte) {}, false) }); err != nil {
+			t.Fatal(err)
+		}
+
+		// 持有src.subMutex, 让移除停在查到src之后
+		src.subMutex.Lock()
+		done := make(chan error)
+		go func() {
+			if unsubscribe {
+				done <- p.Unsubscribe(topic)
+			} else {
+				done <- sub.Unsubscribe()
+			}
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		p.mutex.Lock()
+		p.moveTopics(src, map[string]*Market{topic: dst})
+		p.mutex.Unlock()
+		src.subMutex.Unlock()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("remove did not return")
+		}
+		if dst.hasTopic(topic) || src.hasTopic(topic) {
+			t.Fatalf("unsubscribe=%v: listener left after the topic was moved", unsubscribe)
+		}
+		if _, ok := p.topics[topic]; ok {
+			t.Fatalf("unsubscribe=%v: topic still assigned", unsubscribe)
+		}
+	}
+}
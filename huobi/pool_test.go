@@ -0,0 +1,319 @@
+package huobi_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leek-box/sheep/huobi"
+	"github.com/leek-box/sheep/huobi/huobitest"
+)
+
+// fastBackoff 测试中快速重连
+var fastBackoff = huobi.Backoff{Min: 10 * time.Millisecond, Max: 50 * time.Millisecond, Factor: 2}
+
+// newTestPool 创建连接假行情服务器的MarketPool并启动Loop
+func newTestPool(t *testing.T, srv *huobitest.Server, opts ...huobi.Option) *huobi.MarketPool {
+	opts = append([]huobi.Option{huobi.WithEndpoints(srv.URL), huobi.WithProxy(nil), huobi.WithReconnectBackoff(fastBackoff)}, opts...)
+	p, err := huobi.NewMarketPool(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Loop()
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// recordLogger 记录Info日志, 用于确认rebalance确实迁移了主题
+type recordLogger struct {
+	huobi.Logger
+	mutex sync.Mutex
+	infos map[string]int
+}
+
+func newRecordLogger() *recordLogger {
+	return &recordLogger{Logger: huobi.NopLogger, infos: make(map[string]int)}
+}
+
+func (l *recordLogger) Info(msg string, kv ...interface{}) {
+	l.mutex.Lock()
+	l.infos[msg]++
+	l.mutex.Unlock()
+}
+
+func (l *recordLogger) count(msg string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.infos[msg]
+}
+
+func poolTopic(i int) string {
+	return fmt.Sprintf("market.coin%dusdt.bbo", i)
+}
+
+func TestMarketPoolShards(t *testing.T) {
+	srv := huobitest.NewServer()
+	defer srv.Close()
+	p := newTestPool(t, srv, huobi.WithTopicsPerConnection(2), huobi.WithMaxConnections(3))
+
+	subs := make([]*huobi.Subscription, 6)
+	var wg sync.WaitGroup
+	for i := range subs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sub, err := p.ListenRaw(poolTopic(i), func(string, []byte) {})
+			if err != nil {
+				t.Error(err)
+			}
+			subs[i] = sub
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	if n := srv.Connections(); n != 3 {
+		t.Fatalf("%d connections for 6 topics, want 3", n)
+	}
+	if _, err := p.ListenRaw(poolTopic(6), func(string, []byte) {}); err != huobi.MarketPoolFullError {
+		t.Fatalf("listen on a full pool returned %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, sub := range subs {
+		if err := sub.Unsubscribe(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range subs {
+		if err := srv.WaitUnsubscribed(ctx, poolTopic(i)); err != nil {
+			t.Fatalf("%s: %v", poolTopic(i), err)
+		}
+	}
+	// 释放的主题可以重新分配
+	if _, err := p.ListenRaw(poolTopic(6), func(string, []byte) {}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarketPoolRebalance(t *testing.T) {
+	srv := huobitest.NewServer()
+	defer srv.Close()
+	logger := newRecordLogger()
+	p := newTestPool(t, srv, huobi.WithTopicsPerConnection(4), huobi.WithLogger(logger))
+
+	// 前4个主题在第一个连接上, 第5个新建连接
+	var mutex sync.Mutex
+	received := make(map[string]int)
+	subs := make([]*huobi.Subscription, 5)
+	for i := range subs {
+		sub, err := p.ListenRaw(poolTopic(i), func(topic string, msg []byte) {
+			mutex.Lock()
+			received[topic]++
+			mutex.Unlock()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs[i] = sub
+	}
+
+	// 重连后第一个连接超出平均数的主题迁移到第二个连接, 每个主题仍只订阅一次
+	srv.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.WaitAccepted(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+	for i := range subs {
+		if err := srv.WaitSubscribed(ctx, poolTopic(i)); err != nil {
+			t.Fatalf("%s not resubscribed: %v", poolTopic(i), err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if logger.count("market pool rebalance") == 0 {
+		t.Fatal("no topic moved after reconnect")
+	}
+	for i := range subs {
+		if n := srv.Push(poolTopic(i), map[string]interface{}{"bid": 1}); n != 1 {
+			t.Fatalf("%s subscribed on %d connections after rebalance", poolTopic(i), n)
+		}
+	}
+
+	for i := range subs {
+		for {
+			mutex.Lock()
+			n := received[poolTopic(i)]
+			mutex.Unlock()
+			if n > 0 {
+				break
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("%s received no push after rebalance", poolTopic(i))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// 迁移后的句柄仍能取消订阅
+	for _, sub := range subs {
+		if err := sub.Unsubscribe(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range subs {
+		if err := srv.WaitUnsubscribed(ctx, poolTopic(i)); err != nil {
+			t.Fatalf("%s: %v", poolTopic(i), err)
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i := range subs {
+		if received[poolTopic(i)] != 1 {
+			t.Fatalf("%s received %d pushes, want 1", poolTopic(i), received[poolTopic(i)])
+		}
+	}
+}
+
+// 取消订阅与rebalance并发时, 句柄无论在哪个连接上都要被移除, 服务器端不能残留订阅
+func TestMarketPoolUnsubscribeDuringRebalance(t *testing.T) {
+	logger := newRecordLogger()
+	for round := 0; round < 20; round++ {
+		srv := huobitest.NewServer()
+		p, err := huobi.NewMarketPool(huobi.WithEndpoints(srv.URL), huobi.WithProxy(nil),
+			huobi.WithReconnectBackoff(fastBackoff), huobi.WithTopicsPerConnection(10), huobi.WithLogger(logger))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go p.Loop()
+
+		// 第一个连接10个主题, 第二个1个, 重连后迁移4个
+		subs := make([]*huobi.Subscription, 11)
+		for i := range subs {
+			if subs[i], err = p.ListenRaw(poolTopic(i), func(string, []byte) {}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 取消订阅分散在重连和迁移的过程中
+		var wg sync.WaitGroup
+		srv.Disconnect()
+		for i, sub := range subs {
+			wg.Add(1)
+			go func(i int, sub *huobi.Subscription) {
+				defer wg.Done()
+				time.Sleep(time.Duration(round+i) * time.Millisecond)
+				sub.Unsubscribe()
+			}(i, sub)
+		}
+		wg.Wait()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := srv.WaitAccepted(ctx, 4); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		for i := range subs {
+			if err := srv.WaitUnsubscribed(ctx, poolTopic(i)); err != nil {
+				t.Fatalf("round %d: %s still subscribed: %v", round, poolTopic(i), err)
+			}
+		}
+		cancel()
+		p.Close()
+		srv.Close()
+	}
+	t.Logf("topics moved in %d of 20 rounds", logger.count("market pool rebalance"))
+}
+
+// 某个连接一直重连失败时, 只有它上面的订阅收到错误, 其他连接照常推送, 主题之后可以重新订阅
+func TestMarketPoolFailingShard(t *testing.T) {
+	srv := huobitest.NewServer()
+	defer srv.Close()
+	backoff := fastBackoff
+	backoff.MaxAttempts = 2
+	p := newTestPool(t, srv, huobi.WithTopicsPerConnection(1), huobi.WithReconnectBackoff(backoff))
+
+	var mutex sync.Mutex
+	var closed int
+	p.OnStateChange(func(state huobi.ConnState, err error) {
+		// Close主动关闭时err为nil
+		if state == huobi.StateClosed && err != nil {
+			mutex.Lock()
+			closed++
+			mutex.Unlock()
+			if err != huobi.ReconnectExhaustedError {
+				t.Errorf("closed with %v, want %v", err, huobi.ReconnectExhaustedError)
+			}
+		}
+	})
+
+	received := make(chan string, 16)
+	listen := func(topic string) *huobi.Subscription {
+		sub, err := p.ListenRaw(topic, func(topic string, msg []byte) {
+			received <- topic
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sub
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	receive := func(topic string) {
+		t.Helper()
+		for {
+			srv.Push(topic, map[string]interface{}{"bid": 1})
+			select {
+			case got := <-received:
+				if got == topic {
+					return
+				}
+			case <-time.After(20 * time.Millisecond):
+			case <-ctx.Done():
+				t.Fatalf("%s: no push received", topic)
+			}
+		}
+	}
+
+	failing := listen(poolTopic(0))
+	listen(poolTopic(1))
+	if n := srv.Connections(); n != 2 {
+		t.Fatalf("%d connections, want 2", n)
+	}
+
+	srv.RejectConnections(true)
+	if n := srv.DisconnectTopic(poolTopic(0)); n != 1 {
+		t.Fatalf("disconnected %d connections, want 1", n)
+	}
+	select {
+	case err := <-failing.Err():
+		if err != huobi.ReconnectExhaustedError {
+			t.Fatalf("subscription error %v, want %v", err, huobi.ReconnectExhaustedError)
+		}
+	case <-ctx.Done():
+		t.Fatal("no error on the subscription of the failed connection")
+	}
+	if _, ok := <-failing.Err(); ok {
+		t.Fatal("subscription of the failed connection not closed")
+	}
+	mutex.Lock()
+	if closed != 1 {
+		t.Fatalf("%d connections closed, want 1", closed)
+	}
+	mutex.Unlock()
+
+	// 其他连接不受影响
+	receive(poolTopic(1))
+
+	// 失败连接上的主题释放后可以重新订阅, 分配到新建的连接上
+	srv.RejectConnections(false)
+	listen(poolTopic(0))
+	receive(poolTopic(0))
+	if n := srv.Connections(); n != 2 {
+		t.Fatalf("%d connections after resubscribing, want 2", n)
+	}
+}
@@ -0,0 +1,293 @@
+package huobi
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 组合估值的计价币种
+const (
+	QuoteUSDT = "usdt"
+	QuoteBTC  = "btc"
+)
+
+// Portfolio的默认配置
+const (
+	defaultPortfolioRefresh = time.Minute
+	defaultEquityHistory    = 1440
+)
+
+// PortfolioNotReadyError 还没有成功刷新过
+var PortfolioNotReadyError = errors.New("portfolio not refreshed yet")
+
+// Holding 一个币种的持仓, 由余额列表中同币种的trade和frozen两行合并而来
+type Holding struct {
+	Currency  string
+	Available float64 // 可用, 对应trade
+	Frozen    float64 // 冻结, 对应frozen
+	Total     float64
+
+	// 以计价币种表示的单价及市值, 没有行情时为0
+	Price  float64
+	Value  float64
+	Priced bool
+}
+
+// MergeBalance 将余额列表按币种合并, 去掉余额为0的币种, 按币种排序
+func MergeBalance(balance *Balance) []Holding {
+	byCurrency := make(map[string]*Holding)
+	for _, sub := range balance.List {
+		amount, err := strconv.ParseFloat(sub.Balance, 64)
+		if err != nil || amount == 0 {
+			continue
+		}
+		h, ok := byCurrency[sub.Currency]
+		if !ok {
+			h = &Holding{Currency: sub.Currency}
+			byCurrency[sub.Currency] = h
+		}
+		switch sub.Type {
+		case "trade":
+			h.Available += amount
+		case "frozen":
+			h.Frozen += amount
+		}
+		h.Total = h.Available + h.Frozen
+	}
+
+	holdings := make([]Holding, 0, len(byCurrency))
+	for _, h := range byCurrency {
+		holdings = append(holdings, *h)
+	}
+	sort.Slice(holdings, func(i, j int) bool {
+		return holdings[i].Currency < holdings[j].Currency
+	})
+	return holdings
+}
+
+// PortfolioSnapshot 某一时刻的组合
+type PortfolioSnapshot struct {
+	Time  time.Time
+	Quote string
+	// 按市值从大到小排列, 不含粉尘, 没有行情的币种排在最后
+	Holdings []Holding
+	// 总权益, 不含没有行情的币种
+	Equity float64
+	// 没有行情无法估值的币种
+	Unpriced []string
+}
+
+// EquityPoint 一次刷新时的总权益
+type EquityPoint struct {
+	Time   time.Time
+	Equity float64
+}
+
+// PortfolioListener 组合刷新后调用
+type PortfolioListener = func(snapshot *PortfolioSnapshot)
+
+// Portfolio 现货账户的组合视图
+// 合并各币种的可用和冻结余额, 使用market.tickers的最新价以USDT或BTC估值, 并记录总权益的变化
+type Portfolio struct {
+	h *Huobi
+
+	// Quote 计价币种, QuoteUSDT或QuoteBTC
+	Quote string
+	// Dust 市值低于该值(以Quote计)的持仓不显示, 也不计入权益
+	Dust float64
+	// RefreshInterval 定时刷新的间隔, 默认1分钟
+	RefreshInterval time.Duration
+	// MaxHistory 保留的权益记录数, 默认1440
+	MaxHistory int
+
+	// 交易对的最新价
+	prices map[string]float64
+	// 收到第一次行情后关闭
+	priced     chan struct{}
+	pricedOnce sync.Once
+
+	latest    *PortfolioSnapshot
+	history   []EquityPoint
+	listeners []PortfolioListener
+
+	// 有成交时通知Run刷新
+	filled   chan struct{}
+	fillOnce sync.Once
+
+	mutex sync.Mutex
+
+	logger Logger
+}
+
+// NewPortfolio 创建以quote计价的组合视图, 需要调用Run开始刷新
+// 默认的粉尘阈值为1 USDT或0.00002 BTC
+func (h *Huobi) NewPortfolio(quote string) *Portfolio {
+	dust := 1.0
+	if quote == QuoteBTC {
+		dust = 0.00002
+	}
+	return &Portfolio{
+		h:               h,
+		Quote:           quote,
+		Dust:            dust,
+		RefreshInterval: defaultPortfolioRefresh,
+		MaxHistory:      defaultEquityHistory,
+		prices:          make(map[string]float64),
+		priced:          make(chan struct{}),
+		filled:          make(chan struct{}, 1),
+		logger:          h.logger,
+	}
+}
+
+// OnUpdate 添加刷新监听器
+func (p *Portfolio) OnUpdate(listener PortfolioListener) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.listeners = append(p.listeners, listener)
+}
+
+// Snapshot 最近一次刷新的结果
+func (p *Portfolio) Snapshot() (*PortfolioSnapshot, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.latest == nil {
+		return nil, PortfolioNotReadyError
+	}
+	return p.latest, nil
+}
+
+// History 按时间排列的权益记录
+func (p *Portfolio) History() []EquityPoint {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]EquityPoint(nil), p.history...)
+}
+
+// setPrice 记录交易对的最新价
+func (p *Portfolio) setPrice(symbol string, ticker *Ticker) {
+	if ticker.Close <= 0 {
+		return
+	}
+	p.mutex.Lock()
+	p.prices[symbol] = ticker.Close
+	p.mutex.Unlock()
+
+	p.pricedOnce.Do(func() {
+		close(p.priced)
+	})
+}
+
+// price currency以Quote计的价格, 调用方需持有p.mutex
+// 依次尝试直接交易对、反向交易对及经由USDT换算
+func (p *Portfolio) price(currency string) (float64, bool) {
+	if currency == p.Quote {
+		return 1, true
+	}
+	if price, ok := p.prices[currency+p.Quote]; ok {
+		return price, true
+	}
+	if price, ok := p.prices[p.Quote+currency]; ok {
+		return 1 / price, true
+	}
+	if p.Quote != QuoteUSDT {
+		toUSDT, ok1 := p.prices[currency+QuoteUSDT]
+		quoteUSDT, ok2 := p.prices[p.Quote+QuoteUSDT]
+		if ok1 && ok2 {
+			return toUSDT / quoteUSDT, true
+		}
+	}
+	return 0, false
+}
+
+// Refresh 查询余额并重新估值
+func (p *Portfolio) Refresh() (*PortfolioSnapshot, error) {
+	balance, err := p.h.GetAccountBalance()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &PortfolioSnapshot{Time: time.Now(), Quote: p.Quote}
+	var unpriced []Holding
+
+	p.mutex.Lock()
+	for _, holding := range MergeBalance(balance) {
+		price, ok := p.price(holding.Currency)
+		if !ok {
+			unpriced = append(unpriced, holding)
+			snapshot.Unpriced = append(snapshot.Unpriced, holding.Currency)
+			continue
+		}
+		holding.Price = price
+		holding.Value = holding.Total * price
+		holding.Priced = true
+		if holding.Value < p.Dust {
+			continue
+		}
+		snapshot.Holdings = append(snapshot.Holdings, holding)
+		snapshot.Equity += holding.Value
+	}
+	sort.SliceStable(snapshot.Holdings, func(i, j int) bool {
+		return snapshot.Holdings[i].Value > snapshot.Holdings[j].Value
+	})
+	snapshot.Holdings = append(snapshot.Holdings, unpriced...)
+
+	p.latest = snapshot
+	// 还没有行情时权益不完整, 不计入记录
+	if len(p.prices) > 0 {
+		p.history = append(p.history, EquityPoint{Time: snapshot.Time, Equity: snapshot.Equity})
+		if p.MaxHistory > 0 && len(p.history) > p.MaxHistory {
+			p.history = append([]EquityPoint(nil), p.history[len(p.history)-p.MaxHistory:]...)
+		}
+	}
+	listeners := append([]PortfolioListener(nil), p.listeners...)
+	p.mutex.Unlock()
+
+	p.logger.Debug("portfolio refreshed", "equity", snapshot.Equity, "quote", p.Quote, "unpriced", len(snapshot.Unpriced))
+	for _, listener := range listeners {
+		listener(snapshot)
+	}
+	return snapshot, nil
+}
+
+// Run 订阅所有交易对的行情用于估值, 收到第一次行情后刷新, 之后每RefreshInterval及每次有成交时刷新, 直到ctx结束
+// 最多等待RefreshInterval的第一次行情, 超时则先以没有行情的状态刷新
+func (p *Portfolio) Run(ctx context.Context) error {
+	sub, err := p.h.SubscribeTickers(p.setPrice)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.priced:
+	case <-time.After(p.RefreshInterval):
+	}
+
+	p.fillOnce.Do(func() {
+		p.h.Orders().OnFill(func(event *OrderEvent) {
+			select {
+			case p.filled <- struct{}{}:
+			default:
+			}
+		})
+	})
+
+	for {
+		if _, err := p.Refresh(); err != nil {
+			p.logger.Warn("portfolio refresh failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.RefreshInterval):
+		case <-p.filled:
+		}
+	}
+}
@@ -0,0 +1,535 @@
+package huobi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PrivateTimeoutError 等待鉴权或订阅结果超时
+var PrivateTimeoutError = errors.New("private websocket request timeout")
+
+// v2Message v2协议的消息
+type v2Message struct {
+	Action  string          `json:"action"`
+	Ch      string          `json:"ch"`
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// err 将非200的返回码转换为错误
+func (msg *v2Message) err() error {
+	if msg.Code == 200 {
+		return nil
+	}
+	return fmt.Errorf("%s %s failed: %d %s", msg.Action, msg.Ch, msg.Code, msg.Message)
+}
+
+type v2Request struct {
+	Action string      `json:"action"`
+	Ch     string      `json:"ch"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+type v2Pong struct {
+	Action string `json:"action"`
+	Data   struct {
+		TS int64 `json:"ts"`
+	} `json:"data"`
+}
+
+type v2MessageChan = chan *v2Message
+
+// OrderUpdate 订单更新推送 orders#$symbol
+type OrderUpdate struct {
+	EventType       string `json:"eventType"` // creation, trade, cancellation, trigger, deletion
+	Symbol          string `json:"symbol"`
+	AccountID       int64  `json:"accountId"`
+	OrderID         int64  `json:"orderId"`
+	ClientOrderID   string `json:"clientOrderId"`
+	OrderSide       string `json:"orderSide"`
+	OrderPrice      string `json:"orderPrice"`
+	OrderSize       string `json:"orderSize"`
+	OrderValue      string `json:"orderValue"`
+	Type            string `json:"type"`
+	OrderStatus     string `json:"orderStatus"` // submitted, partial-filled, filled, partial-canceled, canceled, rejected
+	OrderCreateTime int64  `json:"orderCreateTime"`
+	TradePrice      string `json:"tradePrice"`
+	TradeVolume     string `json:"tradeVolume"`
+	TradeID         int64  `json:"tradeId"`
+	TradeTime       int64  `json:"tradeTime"`
+	Aggressor       bool   `json:"aggressor"`
+	RemainAmt       string `json:"remainAmt"`
+	ExecAmt         string `json:"execAmt"`
+	LastActTime     int64  `json:"lastActTime"`
+	ErrCode         int    `json:"errCode"`
+	ErrMessage      string `json:"errMessage"`
+}
+
+// TradeClearing 成交清算推送 trade.clearing#$symbol
+type TradeClearing struct {
+	EventType       string `json:"eventType"` // trade, cancellation
+	Symbol          string `json:"symbol"`
+	OrderID         int64  `json:"orderId"`
+	TradePrice      string `json:"tradePrice"`
+	TradeVolume     string `json:"tradeVolume"`
+	OrderSide       string `json:"orderSide"`
+	OrderType       string `json:"orderType"`
+	Aggressor       bool   `json:"aggressor"`
+	TradeID         int64  `json:"tradeId"`
+	TradeTime       int64  `json:"tradeTime"`
+	TransactFee     string `json:"transactFee"`
+	FeeCurrency     string `json:"feeCurrency"`
+	FeeDeduct       string `json:"feeDeduct"`
+	FeeDeductType   string `json:"feeDeductType"`
+	AccountID       int64  `json:"accountId"`
+	Source          string `json:"source"`
+	OrderPrice      string `json:"orderPrice"`
+	OrderSize       string `json:"orderSize"`
+	OrderValue      string `json:"orderValue"`
+	ClientOrderID   string `json:"clientOrderId"`
+	StopPrice       string `json:"stopPrice"`
+	Operator        string `json:"operator"`
+	OrderCreateTime int64  `json:"orderCreateTime"`
+	OrderStatus     string `json:"orderStatus"`
+}
+
+// AccountUpdate 账户变动推送 accounts.update#$mode
+type AccountUpdate struct {
+	Currency    string `json:"currency"`
+	AccountID   int64  `json:"accountId"`
+	Balance     string `json:"balance"`
+	Available   string `json:"available"`
+	ChangeType  string `json:"changeType"`
+	AccountType string `json:"accountType"`
+	ChangeTime  int64  `json:"changeTime"`
+	SeqNum      int64  `json:"seqNum"`
+}
+
+// PrivateListener v2推送监听器, data为推送中的data字段
+type PrivateListener = func(topic string, data json.RawMessage)
+
+// PrivateClient 需要鉴权的资产和订单Websocket客户端
+// 断线后自动重连, 重新鉴权并重新订阅
+type PrivateClient struct {
+	ws          *SafeWebSocket
+	wsMutex     sync.Mutex
+	endpoint    string
+	dialer      *websocket.Dialer
+	credentials CredentialProvider
+
+	listeners           map[string]PrivateListener
+	subscribedTopic     map[string]bool
+	subscribeResultCb   map[string]v2MessageChan
+	unsubscribeResultCb map[string]v2MessageChan
+	authResultCb        v2MessageChan
+
+	// 唯一的重连者, 只在Loop中重连
+	supervisor *supervisor
+
+	// 上次接收到的ping时间戳, 原子访问
+	lastPing int64
+
+	// 检查心跳的时间间隔，默认20秒, 超过3倍间隔未收到服务器的ping则重连
+	HeartbeatInterval time.Duration
+	// 等待鉴权及订阅结果的超时时间，默认10秒
+	ReceiveTimeout time.Duration
+
+	mutex *sync.RWMutex
+
+	logger  Logger
+	metrics Metrics
+}
+
+// NewPrivateClient 创建PrivateClient实例, 连接并完成鉴权
+func NewPrivateClient(provider CredentialProvider, opts ...Option) (*PrivateClient, error) {
+	o := newOptions(opts)
+	p := &PrivateClient{
+		endpoint:            o.privateEndpoint,
+		dialer:              o.network.websocketDialer(),
+		credentials:         provider,
+		HeartbeatInterval:   20 * time.Second,
+		ReceiveTimeout:      10 * time.Second,
+		supervisor:          newSupervisor(o),
+		listeners:           make(map[string]PrivateListener),
+		subscribedTopic:     make(map[string]bool),
+		subscribeResultCb:   make(map[string]v2MessageChan),
+		unsubscribeResultCb: make(map[string]v2MessageChan),
+		mutex:               &sync.RWMutex{},
+		logger:              o.logger,
+		metrics:             o.metrics,
+	}
+
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+	p.supervisor.setState(StateConnected, nil)
+
+	return p, nil
+}
+
+// connect 连接并鉴权
+func (p *PrivateClient) connect() error {
+	p.logger.Debug("private connecting", "endpoint", p.endpoint)
+	ws, err := newSafeWebSocket(p.endpoint, p.dialer, p.logger)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&p.lastPing, getUinxMillisecond())
+	p.wsMutex.Lock()
+	p.ws = ws
+	p.wsMutex.Unlock()
+
+	p.handleMessageLoop(ws)
+	p.keepAlive(ws)
+
+	if err := p.authenticate(); err != nil {
+		ws.Destroy()
+		return err
+	}
+	p.logger.Info("private connected", "endpoint", p.endpoint)
+
+	return nil
+}
+
+// authenticate 使用v2.1签名鉴权, 每次都向provider重新获取凭证
+func (p *PrivateClient) authenticate() error {
+	credentials, err := p.credentials.Credentials()
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(p.endpoint)
+	if err != nil {
+		return err
+	}
+	params, err := createWebsocketAuthParams(credentials, u.Host, u.Path)
+	if err != nil {
+		return err
+	}
+
+	c := make(v2MessageChan, 1)
+	p.mutex.Lock()
+	p.authResultCb = c
+	p.mutex.Unlock()
+
+	defer func() {
+		p.mutex.Lock()
+		if p.authResultCb == c {
+			p.authResultCb = nil
+		}
+		p.mutex.Unlock()
+	}()
+
+	if err := p.sendMessage(v2Request{Action: "req", Ch: "auth", Params: params}); err != nil {
+		return err
+	}
+
+	msg, err := p.wait(c)
+	if err != nil {
+		return err
+	}
+	return msg.err()
+}
+
+// conn 当前连接
+func (p *PrivateClient) conn() *SafeWebSocket {
+	p.wsMutex.Lock()
+	defer p.wsMutex.Unlock()
+	return p.ws
+}
+
+// resubscribe 重连并鉴权成功后重新订阅所有主题
+func (p *PrivateClient) resubscribe() {
+	// 重新订阅
+	p.mutex.Lock()
+	var topics []string
+	for topic := range p.listeners {
+		topics = append(topics, topic)
+		delete(p.subscribedTopic, topic)
+	}
+	p.mutex.Unlock()
+
+	for _, topic := range topics {
+		if err := p.subscribe(topic); err != nil {
+			p.logger.Error("private resubscribe failed", "topic", topic, "err", err)
+		}
+	}
+}
+
+// OnStateChange 添加连接状态监听器
+func (p *PrivateClient) OnStateChange(listener StateListener) {
+	p.supervisor.OnStateChange(listener)
+}
+
+// State 当前连接状态
+func (p *PrivateClient) State() ConnState {
+	return p.supervisor.State()
+}
+
+// wait 等待结果, 超时返回PrivateTimeoutError
+func (p *PrivateClient) wait(c v2MessageChan) (*v2Message, error) {
+	select {
+	case msg := <-c:
+		return msg, nil
+	case <-time.After(p.ReceiveTimeout):
+		return nil, PrivateTimeoutError
+	}
+}
+
+// sendMessage 发送消息
+func (p *PrivateClient) sendMessage(data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	// 鉴权请求的参数含accessKey和signature, 以结构化字段输出才能被脱敏
+	if req, ok := data.(v2Request); ok {
+		p.logger.Debug("private sendMessage", "action", req.Action, "ch", req.Ch, "params", req.Params)
+	} else {
+		p.logger.Debug("private sendMessage", "message", string(b))
+	}
+	return p.conn().Send(b)
+}
+
+// handleMessageLoop 处理消息循环, v2协议的消息未压缩
+func (p *PrivateClient) handleMessageLoop(ws *SafeWebSocket) {
+	ws.Listen(func(buf []byte) {
+		p.logger.Debug("private readMessage", "message", string(buf))
+		var msg v2Message
+		if err := json.Unmarshal(buf, &msg); err != nil {
+			p.logger.Error("parse private message failed", "err", err)
+			p.metrics.IncDecodeFailure("")
+			return
+		}
+
+		switch msg.Action {
+		case "ping":
+			p.handlePing(msg.Data)
+
+		case "req":
+			if msg.Ch == "auth" {
+				p.mutex.RLock()
+				c := p.authResultCb
+				p.mutex.RUnlock()
+				if c != nil {
+					select {
+					case c <- &msg:
+					default:
+					}
+				}
+			}
+
+		case "sub":
+			p.deliver(p.subscribeResultCb, &msg)
+
+		case "unsub":
+			p.deliver(p.unsubscribeResultCb, &msg)
+
+		case "push":
+			p.metrics.ObserveMessage(msg.Ch, len(buf))
+			p.mutex.RLock()
+			listener, ok := p.listeners[msg.Ch]
+			p.mutex.RUnlock()
+			if ok {
+				listener(msg.Ch, msg.Data)
+			}
+		}
+	})
+}
+
+// deliver 将订阅或取消订阅的结果投递给等待中的调用方, 不阻塞
+func (p *PrivateClient) deliver(cbs map[string]v2MessageChan, msg *v2Message) {
+	p.mutex.RLock()
+	c, ok := cbs[msg.Ch]
+	p.mutex.RUnlock()
+	if ok {
+		select {
+		case c <- msg:
+		default:
+		}
+	}
+}
+
+// handlePing 回复服务器的ping
+func (p *PrivateClient) handlePing(data json.RawMessage) {
+	var pong v2Pong
+	pong.Action = "pong"
+	if err := json.Unmarshal(data, &pong.Data); err != nil {
+		p.logger.Error("parse private ping failed", "err", err)
+		return
+	}
+	p.logger.Debug("private handlePing", "ping", pong.Data.TS)
+	atomic.StoreInt64(&p.lastPing, getUinxMillisecond())
+	p.sendMessage(pong)
+}
+
+// keepAlive 检查服务器心跳, 长时间未收到ping则断开连接交给Loop重连
+func (p *PrivateClient) keepAlive(ws *SafeWebSocket) {
+	ws.KeepAlive(p.HeartbeatInterval, func() {
+		tr := time.Duration(getUinxMillisecond()-atomic.LoadInt64(&p.lastPing)) * time.Millisecond
+		if tr >= p.HeartbeatInterval*3 {
+			p.logger.Warn("private no ping max delay", "delay", tr, "max", p.HeartbeatInterval*3)
+			ws.fail(HeartbeatTimeoutError)
+		}
+	})
+}
+
+// Subscribe 订阅主题, 同一主题只保留最后设置的监听器, 订阅失败时移除监听器
+func (p *PrivateClient) Subscribe(topic string, listener PrivateListener) error {
+	p.mutex.Lock()
+	p.listeners[topic] = listener
+	p.mutex.Unlock()
+
+	err := p.subscribe(topic)
+	if err != nil {
+		p.mutex.Lock()
+		// 期间其他调用已订阅成功时保留监听器
+		if !p.subscribedTopic[topic] {
+			delete(p.listeners, topic)
+		}
+		p.mutex.Unlock()
+	}
+	return err
+}
+
+// subscribe 发送订阅指令并等待订阅结果
+func (p *PrivateClient) subscribe(topic string) error {
+	p.mutex.Lock()
+	if _, ok := p.subscribedTopic[topic]; ok {
+		p.mutex.Unlock()
+		return nil
+	}
+	c := make(v2MessageChan, 1)
+	p.subscribeResultCb[topic] = c
+	p.subscribedTopic[topic] = true
+	p.mutex.Unlock()
+
+	p.logger.Debug("private subscribe", "topic", topic)
+	err := p.sendMessage(v2Request{Action: "sub", Ch: topic})
+	if err == nil {
+		var msg *v2Message
+		if msg, err = p.wait(c); err == nil {
+			err = msg.err()
+		}
+	}
+
+	p.mutex.Lock()
+	delete(p.subscribeResultCb, topic)
+	if err != nil {
+		delete(p.subscribedTopic, topic)
+	}
+	p.mutex.Unlock()
+
+	p.metrics.SetSubscribed(topic, err == nil)
+	return err
+}
+
+// Unsubscribe 取消订阅, 发送unsub指令并等待结果, 超时返回PrivateTimeoutError
+func (p *PrivateClient) Unsubscribe(topic string) error {
+	p.logger.Debug("private unSubscribe", "topic", topic)
+	p.mutex.Lock()
+	delete(p.listeners, topic)
+	_, subscribed := p.subscribedTopic[topic]
+	delete(p.subscribedTopic, topic)
+	p.metrics.SetSubscribed(topic, false)
+	if !subscribed {
+		p.mutex.Unlock()
+		return nil
+	}
+	c := make(v2MessageChan, 1)
+	p.unsubscribeResultCb[topic] = c
+	p.mutex.Unlock()
+
+	defer func() {
+		p.mutex.Lock()
+		if p.unsubscribeResultCb[topic] == c {
+			delete(p.unsubscribeResultCb, topic)
+		}
+		p.mutex.Unlock()
+	}()
+
+	if err := p.sendMessage(v2Request{Action: "unsub", Ch: topic}); err != nil {
+		return err
+	}
+	msg, err := p.wait(c)
+	if err != nil {
+		return err
+	}
+	return msg.err()
+}
+
+// decode 将推送解码到v, 失败时记录日志和指标
+func (p *PrivateClient) decode(topic string, data json.RawMessage, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		p.logger.Error("decode private message failed", "topic", topic, "err", err)
+		p.metrics.IncDecodeFailure(topic)
+	}
+	return err
+}
+
+// OrderUpdateListener 订单更新监听器
+type OrderUpdateListener = func(update *OrderUpdate)
+
+// SubscribeOrders 订阅订单更新 orders#$symbol, symbol为*时订阅所有交易对
+func (p *PrivateClient) SubscribeOrders(symbol string, listener OrderUpdateListener) error {
+	return p.Subscribe("orders#"+symbol, func(topic string, data json.RawMessage) {
+		var update OrderUpdate
+		if p.decode(topic, data, &update) != nil {
+			return
+		}
+		listener(&update)
+	})
+}
+
+// TradeClearingListener 成交清算监听器
+type TradeClearingListener = func(clearing *TradeClearing)
+
+// SubscribeTradeClearing 订阅成交清算 trade.clearing#$symbol, symbol为*时订阅所有交易对
+func (p *PrivateClient) SubscribeTradeClearing(symbol string, listener TradeClearingListener) error {
+	return p.Subscribe("trade.clearing#"+symbol, func(topic string, data json.RawMessage) {
+		var clearing TradeClearing
+		if p.decode(topic, data, &clearing) != nil {
+			return
+		}
+		listener(&clearing)
+	})
+}
+
+// AccountUpdateListener 账户变动监听器
+type AccountUpdateListener = func(update *AccountUpdate)
+
+// SubscribeAccounts 订阅账户变动 accounts.update#$mode
+// mode: 0 仅余额变动时推送, 1 余额或可用余额变动时推送, 2 余额或可用余额变动时都推送且包含两者
+func (p *PrivateClient) SubscribeAccounts(mode int, listener AccountUpdateListener) error {
+	return p.Subscribe("accounts.update#"+strconv.Itoa(mode), func(topic string, data json.RawMessage) {
+		var update AccountUpdate
+		if p.decode(topic, data, &update) != nil {
+			return
+		}
+		listener(&update)
+	})
+}
+
+// Loop 进入循环, 连接断开后按退避策略重连并重新鉴权, 直到Close或重连次数用尽
+func (p *PrivateClient) Loop() {
+	p.logger.Debug("private startLoop")
+	p.supervisor.run(p.conn, p.connect, p.resubscribe)
+	p.logger.Debug("private endLoop")
+}
+
+// Close 关闭连接, 不再重连
+func (p *PrivateClient) Close() error {
+	p.logger.Info("private close")
+	p.supervisor.close()
+	return p.conn().Destroy()
+}
@@ -0,0 +1,191 @@
+package huobi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/leek-box/sheep/huobi"
+)
+
+// v2Server 校验鉴权签名的v2 websocket服务器
+type v2Server struct {
+	*httptest.Server
+	secretKey string
+	rejected  map[string]bool // 拒绝订阅的主题
+
+	mutex   sync.Mutex
+	conns   []*websocket.Conn
+	unsubs  []string
+	writeMu sync.Mutex
+}
+
+func newV2Server(t *testing.T, secretKey string) *v2Server {
+	s := &v2Server{secretKey: secretKey, rejected: make(map[string]bool)}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		s.mutex.Lock()
+		s.conns = append(s.conns, c)
+		s.mutex.Unlock()
+
+		authed := false
+		for {
+			var req struct {
+				Action string            `json:"action"`
+				Ch     string            `json:"ch"`
+				Params map[string]string `json:"params"`
+			}
+			if err := c.ReadJSON(&req); err != nil {
+				return
+			}
+			reply := map[string]interface{}{"action": req.Action, "ch": req.Ch, "code": 200}
+			switch {
+			case req.Action == "req" && req.Ch == "auth":
+				if authed = s.verify(r, req.Params); !authed {
+					reply["code"], reply["message"] = 2002, "invalid signature"
+				}
+			case !authed:
+				reply["code"], reply["message"] = 2002, "not authenticated"
+			case req.Action == "sub" && s.rejected[req.Ch]:
+				reply["code"], reply["message"] = 2001, "invalid topic"
+			case req.Action == "unsub":
+				s.mutex.Lock()
+				s.unsubs = append(s.unsubs, req.Ch)
+				s.mutex.Unlock()
+			}
+			s.write(c, reply)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// verify 按签名版本2.1校验鉴权参数
+func (s *v2Server) verify(r *http.Request, params map[string]string) bool {
+	signature := params["signature"]
+	signed := make(map[string]string)
+	for k, v := range params {
+		if k != "signature" && k != "authType" {
+			signed[k] = v
+		}
+	}
+	payload := huobi.SignaturePayload(signed, "GET", r.Host, r.URL.Path)
+	want, _ := huobi.NewHmacSigner(s.secretKey).Sign(payload)
+	return params["accessKey"] == "ak" && params["signatureVersion"] == "2.1" && signature == want
+}
+
+func (s *v2Server) write(c *websocket.Conn, v interface{}) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	c.WriteJSON(v)
+}
+
+// push 向所有连接推送
+func (s *v2Server) push(ch string, data interface{}) {
+	s.mutex.Lock()
+	conns := append([]*websocket.Conn(nil), s.conns...)
+	s.mutex.Unlock()
+	for _, c := range conns {
+		s.write(c, map[string]interface{}{"action": "push", "ch": ch, "data": data})
+	}
+}
+
+func (s *v2Server) unsubscribed() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.unsubs...)
+}
+
+func (s *v2Server) endpoint() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/v2"
+}
+
+func newPrivateClient(s *v2Server, secretKey string) (*huobi.PrivateClient, error) {
+	return huobi.NewPrivateClient(huobi.NewStaticProvider("ak", huobi.NewHmacSigner(secretKey)),
+		huobi.WithPrivateEndpoint(s.endpoint()), huobi.WithProxy(nil))
+}
+
+func TestPrivateAuth(t *testing.T) {
+	s := newV2Server(t, "sk")
+	p, err := newPrivateClient(s, "sk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	updates := make(chan *huobi.OrderUpdate, 1)
+	if err := p.SubscribeOrders("btcusdt", func(update *huobi.OrderUpdate) {
+		updates <- update
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.push("orders#btcusdt", map[string]interface{}{"eventType": "creation", "symbol": "btcusdt", "orderId": 1, "orderStatus": "submitted"})
+	select {
+	case update := <-updates:
+		if update.OrderID != 1 || update.OrderStatus != "submitted" {
+			t.Fatalf("unexpected update %+v", update)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("order update not received")
+	}
+
+	if err := p.Unsubscribe("orders#btcusdt"); err != nil {
+		t.Fatal(err)
+	}
+	if unsubs := s.unsubscribed(); len(unsubs) != 1 || unsubs[0] != "orders#btcusdt" {
+		t.Fatalf("server received unsub %v", unsubs)
+	}
+}
+
+func TestPrivateAuthFailure(t *testing.T) {
+	s := newV2Server(t, "sk")
+	start := time.Now()
+	_, err := newPrivateClient(s, "wrong")
+	if err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("NewPrivateClient with a wrong secret returned %v", err)
+	}
+	// 鉴权失败应立即返回, 而不是等到超时
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("auth failure took %v", d)
+	}
+}
+
+func TestPrivateSubscribeFailure(t *testing.T) {
+	s := newV2Server(t, "sk")
+	s.rejected["orders#bad"] = true
+	p, err := newPrivateClient(s, "sk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	called := make(chan struct{}, 1)
+	err = p.Subscribe("orders#bad", func(topic string, data json.RawMessage) {
+		called <- struct{}{}
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid topic") {
+		t.Fatalf("Subscribe to a rejected topic returned %v", err)
+	}
+
+	// 订阅失败后监听器已移除, 服务器的推送不再交给它
+	s.push("orders#bad", map[string]interface{}{"orderId": 1})
+	if err := p.SubscribeOrders("btcusdt", func(update *huobi.OrderUpdate) {}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-called:
+		t.Fatal("listener of a failed subscription received a push")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
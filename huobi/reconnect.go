@@ -0,0 +1,207 @@
+package huobi
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ConnState 连接状态
+type ConnState int
+
+const (
+	// StateConnecting 正在建立连接
+	StateConnecting ConnState = iota
+	// StateConnected 已连接, 订阅均已恢复
+	StateConnected
+	// StateResubscribing 已重新连接, 正在恢复订阅, 此时的行情可能不完整
+	StateResubscribing
+	// StateDisconnected 连接已断开, 等待重连
+	StateDisconnected
+	// StateClosed 已关闭, 不再重连
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateResubscribing:
+		return "resubscribing"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StateListener 连接状态监听器, err为导致断开或关闭的错误
+// 在连接的循环协程中同步调用, 不应阻塞
+type StateListener = func(state ConnState, err error)
+
+// HeartbeatTimeoutError 长时间未收到服务器心跳
+var HeartbeatTimeoutError = errors.New("heartbeat timeout")
+
+// ReconnectExhaustedError 重连次数达到上限
+var ReconnectExhaustedError = errors.New("reconnect attempts exhausted")
+
+// reconnectRequestedError 用户主动要求重连
+var reconnectRequestedError = errors.New("reconnect requested by user")
+
+// Backoff 重连的指数退避策略
+type Backoff struct {
+	Min         time.Duration // 第一次重连前的等待时间
+	Max         time.Duration // 等待时间上限
+	Factor      float64       // 每次失败后等待时间的倍数
+	Jitter      float64       // 随机抖动比例, 0.2表示在±20%范围内浮动
+	MaxAttempts int           // 连续重连失败的次数上限, 0表示不限制
+}
+
+// DefaultBackoff 默认的重连策略
+var DefaultBackoff = Backoff{
+	Min:    time.Second,
+	Max:    30 * time.Second,
+	Factor: 2,
+	Jitter: 0.2,
+}
+
+// delay 第attempt次重连前的等待时间, attempt从1开始
+func (b Backoff) delay(attempt int) time.Duration {
+	d := float64(b.Min) * math.Pow(b.Factor, float64(attempt-1))
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d *= 1 - b.Jitter + 2*b.Jitter*rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// supervisor 连接的唯一重连者, 连接结束后按退避策略重连并通知状态变化
+type supervisor struct {
+	backoff Backoff
+
+	state     ConnState
+	listeners []StateListener
+	mutex     sync.Mutex
+
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	logger  Logger
+	metrics Metrics
+}
+
+func newSupervisor(o *options) *supervisor {
+	return &supervisor{
+		backoff: o.backoff,
+		closing: make(chan struct{}),
+		logger:  o.logger,
+		metrics: o.metrics,
+	}
+}
+
+// OnStateChange 添加状态监听器
+func (s *supervisor) OnStateChange(listener StateListener) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// State 当前状态
+func (s *supervisor) State() ConnState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
+
+func (s *supervisor) setState(state ConnState, err error) {
+	s.mutex.Lock()
+	if s.state == state {
+		s.mutex.Unlock()
+		return
+	}
+	s.state = state
+	listeners := append([]StateListener(nil), s.listeners...)
+	s.mutex.Unlock()
+
+	s.logger.Info("connection state", "state", state.String(), "err", err)
+	for _, listener := range listeners {
+		listener(state, err)
+	}
+}
+
+// close 停止重连
+func (s *supervisor) close() {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+}
+
+func (s *supervisor) closed() bool {
+	select {
+	case <-s.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// run 等待当前连接结束后重连, 直到被关闭或重连次数用尽
+// conn返回当前连接, connect建立新连接, restore在新连接上恢复订阅
+func (s *supervisor) run(conn func() *SafeWebSocket, connect func() error, restore func()) {
+	for {
+		err := conn().Loop()
+		if s.closed() {
+			s.setState(StateClosed, nil)
+			return
+		}
+		s.logger.Warn("connection lost", "err", err)
+		s.setState(StateDisconnected, err)
+
+		if err := s.redial(conn, connect); err != nil {
+			s.setState(StateClosed, err)
+			return
+		}
+		if s.closed() {
+			s.setState(StateClosed, nil)
+			return
+		}
+
+		s.setState(StateResubscribing, nil)
+		restore()
+		s.setState(StateConnected, nil)
+	}
+}
+
+// redial 按退避策略建立新连接, 等待期间被关闭时直接返回
+func (s *supervisor) redial(conn func() *SafeWebSocket, connect func() error) error {
+	for attempt := 1; s.backoff.MaxAttempts <= 0 || attempt <= s.backoff.MaxAttempts; attempt++ {
+		delay := s.backoff.delay(attempt)
+		s.logger.Info("reconnecting", "attempt", attempt, "delay", delay)
+		select {
+		case <-s.closing:
+			return nil
+		case <-time.After(delay):
+		}
+
+		s.metrics.IncReconnect()
+		s.setState(StateConnecting, nil)
+		if err := connect(); err != nil {
+			s.logger.Error("reconnect failed", "attempt", attempt, "err", err)
+			continue
+		}
+
+		// 连接期间被关闭, 丢弃新连接
+		if s.closed() {
+			conn().Destroy()
+		}
+		return nil
+	}
+	return ReconnectExhaustedError
+}
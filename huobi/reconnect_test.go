@@ -0,0 +1,105 @@
+package huobi
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Min: 100 * time.Millisecond, Max: time.Second, Factor: 2}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if d := b.delay(i + 1); d != w*time.Millisecond {
+			t.Fatalf("attempt %d: delay %v, want %v", i+1, d, w*time.Millisecond)
+		}
+	}
+
+	// 抖动不超出±Jitter, 上限之后也一样
+	b.Jitter = 0.2
+	for attempt := 1; attempt <= 8; attempt++ {
+		base := Backoff{Min: b.Min, Max: b.Max, Factor: b.Factor}.delay(attempt)
+		for i := 0; i < 100; i++ {
+			d := b.delay(attempt)
+			if d < base*8/10 || d > base*12/10 {
+				t.Fatalf("attempt %d: delay %v outside %v±20%%", attempt, d, base)
+			}
+		}
+	}
+}
+
+// stateRecorder 记录状态变化的顺序
+type stateRecorder struct {
+	mutex  sync.Mutex
+	states []ConnState
+}
+
+func (r *stateRecorder) listen(state ConnState, err error) {
+	r.mutex.Lock()
+	r.states = append(r.states, state)
+	r.mutex.Unlock()
+}
+
+func (r *stateRecorder) get() []ConnState {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]ConnState(nil), r.states...)
+}
+
+func newTestSupervisor(b Backoff) *supervisor {
+	return newSupervisor(&options{backoff: b, logger: NopLogger, metrics: NopMetrics})
+}
+
+func TestSupervisorMaxAttempts(t *testing.T) {
+	s := newTestSupervisor(Backoff{Min: time.Millisecond, Max: time.Millisecond, Factor: 2, MaxAttempts: 3})
+	var r stateRecorder
+	s.OnStateChange(r.listen)
+	s.setState(StateDisconnected, nil)
+
+	attempts := 0
+	errDial := errors.New("dial failed")
+	err := s.redial(nil, func() error {
+		attempts++
+		return errDial
+	})
+	if err != ReconnectExhaustedError {
+		t.Fatalf("redial returned %v, want %v", err, ReconnectExhaustedError)
+	}
+	if attempts != 3 {
+		t.Fatalf("%d attempts, want 3", attempts)
+	}
+	// 连续失败时停留在connecting, 不重复通知
+	want := []ConnState{StateDisconnected, StateConnecting}
+	got := r.get()
+	if len(got) != len(want) {
+		t.Fatalf("states %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("states %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSupervisorCloseDuringBackoff(t *testing.T) {
+	s := newTestSupervisor(Backoff{Min: time.Hour, Max: time.Hour, Factor: 2})
+	done := make(chan error)
+	go func() {
+		done <- s.redial(nil, func() error {
+			t.Error("connect called after close")
+			return nil
+		})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("redial returned %v after close", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("redial still waiting after close")
+	}
+}
@@ -2,125 +2,293 @@ package huobi
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
-const host = "https://api.huobi.pro"
+// DefaultRestHost REST接口的默认地址
+const DefaultRestHost = "https://api.huobi.pro"
 
-// Http Get请求基础函数, 通过封装Go语言Http请求, 支持火币网REST API的HTTP Get请求
-// strUrl: 请求的URL
-// strParams: string类型的请求参数, user=lxz&pwd=lxz
-// return: 请求结果
-func httpGetRequest(strUrl string, mapParams map[string]string) string {
-	httpClient := &http.Client{}
+// RestRequest 一次REST调用, 在中间件链中传递
+type RestRequest struct {
+	Method   string            // 请求方法, GET或POST
+	Endpoint string            // 逻辑路由路径, 如/v1/order/orders
+	Params   map[string]string // 业务参数, 不含签名参数
+	URL      string            // 签名后的完整请求地址
+	Body     string            // POST请求体
+	Header   http.Header       // 请求头
 
-	var strRequestUrl string
-	if nil == mapParams {
-		strRequestUrl = strUrl
-	} else {
-		strParams := map2UrlQuery(mapParams)
-		strRequestUrl = strUrl + "?" + strParams
+	credentials *Credentials
+	// REST接口地址, 如https://api.huobi.pro
+	baseURL string
+}
+
+// RestResponse REST调用结果
+type RestResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte // 原始响应内容
+
+	// 从响应内容中解析出的火币通用字段
+	Status  string
+	ErrCode string
+	ErrMsg  string
+
+	// 签名或网络错误, 此时没有响应内容
+	Err error
+}
+
+// RestHandler 处理一次REST调用
+type RestHandler = func(req *RestRequest) *RestResponse
+
+// RestMiddleware REST中间件
+// 可以在调用next前修改请求, 在调用后修改响应, 也可以不调用next直接返回响应
+type RestMiddleware = func(next RestHandler) RestHandler
+
+// clientErrorCode 签名或网络错误时使用的err-code
+const clientErrorCode = "client-error"
+
+// NewRestResponse 构造火币格式的成功响应, 供中间件短路返回使用
+func NewRestResponse(data interface{}) *RestResponse {
+	body, err := json.Marshal(map[string]interface{}{"status": "ok", "data": data})
+	if err != nil {
+		return &RestResponse{Err: err}
 	}
+	return &RestResponse{StatusCode: http.StatusOK, Header: http.Header{}, Body: body, Status: "ok"}
+}
 
-	// 构建Request, 并且按官方要求添加Http Header
-	request, err := http.NewRequest("GET", strRequestUrl, nil)
-	if nil != err {
-		return err.Error()
+// Sign 使用凭证对请求签名, 生成URL和Body
+// 中间件修改了Params后需要重新调用
+func (r *RestRequest) Sign() error {
+	if r.credentials == nil {
+		return errors.New("request has no credentials")
 	}
-	request.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/39.0.2171.71 Safari/537.36")
 
-	// 发出请求
-	response, err := httpClient.Do(request)
+	switch r.Method {
+	case "GET":
+		return r.signGet()
+	case "POST":
+		return r.signPost()
+	}
+	return errors.New("unsupported method " + r.Method)
+}
+
+// signatureParams 签名所需的公共参数
+func (r *RestRequest) signatureParams() map[string]string {
+	mapParams := make(map[string]string)
+	mapParams["AccessKeyId"] = r.credentials.AccessKey
+	mapParams["SignatureMethod"] = r.credentials.Signer.SignatureMethod()
+	mapParams["SignatureVersion"] = "2"
+	mapParams["Timestamp"] = time.Now().UTC().Format("2006-01-02T15:04:05")
+	return mapParams
+}
+
+// hostName 参与签名的主机名
+func (r *RestRequest) hostName() (string, error) {
+	u, err := url.Parse(r.baseURL)
+	if err != nil {
+		return "", err
+	}
+	return u.Host, nil
+}
+
+// 进行签名后的HTTP GET请求, 参考官方Python Demo写的
+// 业务参数与签名参数一起参与签名, 并全部放在查询字符串中
+func (r *RestRequest) signGet() error {
+	mapParams := r.signatureParams()
+	for k, v := range r.Params {
+		mapParams[k] = v
+	}
+
+	hostName, err := r.hostName()
+	if err != nil {
+		return err
+	}
+	signature, err := createSign(mapParams, r.Method, hostName, r.Endpoint, r.credentials.Signer)
 	if nil != err {
-		return err.Error()
+		return err
 	}
-	defer response.Body.Close()
+	mapParams["Signature"] = signature
 
-	// 解析响应内容
-	body, err := ioutil.ReadAll(response.Body)
+	r.URL = r.baseURL + r.Endpoint + "?" + map2UrlQuery(mapValueEncodeURI(mapParams))
+	r.Body = ""
+	return nil
+}
+
+// 进行签名后的HTTP POST请求, 参考官方Python Demo写的
+// 只有签名参数参与签名, 业务参数以JSON放在请求体中
+func (r *RestRequest) signPost() error {
+	mapParams2Sign := r.signatureParams()
+
+	hostName, err := r.hostName()
+	if err != nil {
+		return err
+	}
+	signature, err := createSign(mapParams2Sign, r.Method, hostName, r.Endpoint, r.credentials.Signer)
 	if nil != err {
-		return err.Error()
+		return err
+	}
+	mapParams2Sign["Signature"] = signature
+
+	r.URL = r.baseURL + r.Endpoint + "?" + map2UrlQuery(mapValueEncodeURI(mapParams2Sign))
+	r.Body = ""
+	if nil != r.Params {
+		bytesParams, _ := json.Marshal(r.Params)
+		r.Body = string(bytesParams)
 	}
+	return nil
+}
+
+// RestClient 火币REST客户端, 请求依次经过中间件后发出
+type RestClient struct {
+	httpClient  *http.Client
+	middlewares []RestMiddleware
+
+	mutex sync.RWMutex
+}
+
+// NewRestClient 创建REST客户端
+func NewRestClient(middlewares ...RestMiddleware) *RestClient {
+	return NewRestClientWithHTTPClient(&http.Client{}, middlewares...)
+}
 
-	return string(body)
+// NewRestClientWithHTTPClient 使用指定的http.Client创建REST客户端, 如需经过代理或自定义TLS
+func NewRestClientWithHTTPClient(httpClient *http.Client, middlewares ...RestMiddleware) *RestClient {
+	return &RestClient{
+		httpClient:  httpClient,
+		middlewares: middlewares,
+	}
 }
 
-// Http POST请求基础函数, 通过封装Go语言Http请求, 支持火币网REST API的HTTP POST请求
-// strUrl: 请求的URL
-// mapParams: map类型的请求参数
-// return: 请求结果
-func httpPostRequest(strUrl string, mapParams map[string]string) string {
-	httpClient := &http.Client{}
+// Use 追加中间件, 先添加的中间件在外层
+func (c *RestClient) Use(middlewares ...RestMiddleware) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.middlewares = append(c.middlewares, middlewares...)
+}
 
-	jsonParams := ""
-	if nil != mapParams {
-		bytesParams, _ := json.Marshal(mapParams)
-		jsonParams = string(bytesParams)
+// Do 经过中间件链发出请求
+func (c *RestClient) Do(req *RestRequest) *RestResponse {
+	c.mutex.RLock()
+	handler := c.httpRequest
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		handler = c.middlewares[i](handler)
 	}
+	c.mutex.RUnlock()
+
+	return handler(req)
+}
 
-	request, err := http.NewRequest("POST", strUrl, strings.NewReader(jsonParams))
+// Http请求基础函数, 通过封装Go语言Http请求, 支持火币网REST API的HTTP GET/POST请求
+// 位于中间件链的最内层
+func (c *RestClient) httpRequest(req *RestRequest) *RestResponse {
+	// 构建Request, 并且按官方要求添加Http Header
+	request, err := http.NewRequest(req.Method, req.URL, strings.NewReader(req.Body))
 	if nil != err {
-		return err.Error()
+		return &RestResponse{Err: err}
+	}
+	for k, values := range req.Header {
+		for _, v := range values {
+			request.Header.Add(k, v)
+		}
 	}
-	request.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/39.0.2171.71 Safari/537.36")
-	request.Header.Add("Content-Type", "application/json")
-	request.Header.Add("Accept-Language", "zh-cn")
 
-	response, err := httpClient.Do(request)
+	// 发出请求
+	response, err := c.httpClient.Do(request)
 	if nil != err {
-		return err.Error()
+		return &RestResponse{Err: err}
 	}
 	defer response.Body.Close()
 
+	// 解析响应内容
 	body, err := ioutil.ReadAll(response.Body)
 	if nil != err {
-		return err.Error()
+		return &RestResponse{Err: err}
 	}
 
-	return string(body)
+	resp := &RestResponse{StatusCode: response.StatusCode, Header: response.Header, Body: body}
+	resp.decodeEnvelope()
+	return resp
 }
 
-// 进行签名后的HTTP GET请求, 参考官方Python Demo写的
-// mapParams: map类型的请求参数, key:value
-// strRequest: API路由路径
-// return: 请求结果
-func apiKeyGet(mapParams map[string]string, strRequestPath string, accessKey, secretKey string) string {
-	strMethod := "GET"
-	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05")
-
-	mapParams["AccessKeyId"] = accessKey
-	mapParams["SignatureMethod"] = "HmacSHA256"
-	mapParams["SignatureVersion"] = "2"
-	mapParams["Timestamp"] = timestamp
-
-	hostName := "api.huobi.pro"
-	mapParams["Signature"] = createSign(mapParams, strMethod, hostName, strRequestPath, secretKey)
+// decodeEnvelope 解析火币通用的status/err-code/err-msg字段
+func (r *RestResponse) decodeEnvelope() {
+	var envelope struct {
+		Status  string `json:"status"`
+		ErrCode string `json:"err-code"`
+		ErrMsg  string `json:"err-msg"`
+	}
+	if err := json.Unmarshal(r.Body, &envelope); err != nil {
+		return
+	}
+	r.Status = envelope.Status
+	r.ErrCode = envelope.ErrCode
+	r.ErrMsg = envelope.ErrMsg
+}
 
-	strUrl := host + strRequestPath
-	return httpGetRequest(strUrl, mapValueEncodeURI(mapParams))
+// body 取响应内容, 签名或网络错误时返回火币格式的错误, 调用方可以统一按status判断
+func (r *RestResponse) body() string {
+	if r.Err != nil {
+		b, _ := json.Marshal(map[string]string{"status": "error", "err-code": clientErrorCode, "err-msg": r.Err.Error()})
+		return string(b)
+	}
+	return string(r.Body)
 }
 
-// 进行签名后的HTTP POST请求, 参考官方Python Demo写的
-// mapParams: map类型的请求参数, key:value
-// strRequest: API路由路径
-// return: 请求结果
-func apiKeyPost(mapParams map[string]string, strRequestPath string, accessKey, secretKey string) string {
-	strMethod := "POST"
-	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05")
+// newRestRequest 创建请求, 并按官方要求添加Http Header
+func newRestRequest(baseURL, method, strRequestPath string, mapParams map[string]string, credentials *Credentials) *RestRequest {
+	header := http.Header{}
+	header.Add("User-Agent", "Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/39.0.2171.71 Safari/537.36")
+	if method == "POST" {
+		header.Add("Content-Type", "application/json")
+		header.Add("Accept-Language", "zh-cn")
+	}
+
+	return &RestRequest{
+		Method:      method,
+		Endpoint:    strRequestPath,
+		Params:      mapParams,
+		Header:      header,
+		credentials: credentials,
+		baseURL:     baseURL,
+	}
+}
 
-	mapParams2Sign := make(map[string]string)
-	mapParams2Sign["AccessKeyId"] = accessKey
-	mapParams2Sign["SignatureMethod"] = "HmacSHA256"
-	mapParams2Sign["SignatureVersion"] = "2"
-	mapParams2Sign["Timestamp"] = timestamp
+// metricsMiddleware 记录REST请求耗时及返回的err-code
+func metricsMiddleware(metrics Metrics) RestMiddleware {
+	return func(next RestHandler) RestHandler {
+		return func(req *RestRequest) *RestResponse {
+			start := time.Now()
+			resp := next(req)
 
-	hostName := "api.huobi.pro"
+			errCode := ""
+			if resp.Err != nil {
+				errCode = clientErrorCode
+			} else if resp.Status != "ok" {
+				errCode = resp.ErrCode
+				if errCode == "" {
+					errCode = "unknown"
+				}
+			}
+			metrics.ObserveRequest(metricsEndpoint(req.Endpoint), errCode, time.Since(start))
 
-	mapParams2Sign["Signature"] = createSign(mapParams2Sign, strMethod, hostName, strRequestPath, secretKey)
-	strUrl := host + strRequestPath + "?" + map2UrlQuery(mapValueEncodeURI(mapParams2Sign))
+			return resp
+		}
+	}
+}
 
-	return httpPostRequest(strUrl, mapParams)
+// loggingMiddleware 以Debug级别记录请求及响应
+func loggingMiddleware(logger Logger) RestMiddleware {
+	return func(next RestHandler) RestHandler {
+		return func(req *RestRequest) *RestResponse {
+			resp := next(req)
+			logger.Debug("rest "+req.Method, "path", req.Endpoint, "params", req.Params, "response", resp.body())
+			return resp
+		}
+	}
 }
@@ -0,0 +1,135 @@
+package huobi_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/leek-box/sheep/huobi"
+	"github.com/leek-box/sheep/huobi/huobitest"
+)
+
+// newRestHuobi 创建连接到假REST服务器的Huobi, 行情连接到假Websocket服务器
+func newRestHuobi(t *testing.T, opts ...huobi.Option) (*huobi.Huobi, *huobitest.RestServer) {
+	ws := huobitest.NewServer()
+	t.Cleanup(ws.Close)
+	rest := huobitest.NewRestServer()
+	t.Cleanup(rest.Close)
+	rest.AddAccount("ak", "sk")
+
+	opts = append([]huobi.Option{huobi.WithRestHost(rest.URL), huobi.WithEndpoints(ws.URL), huobi.WithProxy(nil)}, opts...)
+	h, err := huobi.NewHuobi("ak", "sk", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, rest
+}
+
+func TestRestPlaceAndGetOrders(t *testing.T) {
+	h, rest := newRestHuobi(t)
+
+	id, err := h.Place(1.5, 10, "btcusdt", "buy-limit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := h.GetOrders(huobi.OrdersRequestParams{Symbol: "btcusdt", States: "submitted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("got %d orders, want 1", len(orders))
+	}
+	o := orders[0]
+	if strconv.FormatInt(o.ID, 10) != id || o.Symbol != "btcusdt" || o.Type != "buy-limit" || o.Amount != "1.5" || o.Price != "10" {
+		t.Fatalf("unexpected order %+v", o)
+	}
+
+	n, _ := strconv.ParseInt(id, 10, 64)
+	if err := rest.FillOrder(n, 1.5); err != nil {
+		t.Fatal(err)
+	}
+	orders, err = h.GetOrders(huobi.OrdersRequestParams{Symbol: "btcusdt", States: "filled"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].FieldAmount != "1.5" {
+		t.Fatalf("unexpected filled orders %+v", orders)
+	}
+}
+
+func TestRestSignatureRejected(t *testing.T) {
+	h, rest := newRestHuobi(t)
+
+	h.SetCredentialProvider(huobi.NewStaticProvider("ak", huobi.NewHmacSigner("wrong")))
+	if _, err := h.Place(1, 10, "btcusdt", "buy-limit"); err == nil || err.Error() != "Signature not valid: Verification failure" {
+		t.Fatalf("Place with a wrong secret returned %v", err)
+	}
+	if len(rest.Orders()) != 0 {
+		t.Fatal("order created with an invalid signature")
+	}
+}
+
+func TestRestStaleTimestampRejected(t *testing.T) {
+	h, rest := newRestHuobi(t)
+
+	// 窗口为负时任何时间戳都已过期
+	rest.SetTimestampWindow(-time.Second)
+	if _, err := h.Place(1, 10, "btcusdt", "buy-limit"); err == nil || err.Error() != "Signature not valid: Timestamp expired" {
+		t.Fatalf("Place with a stale timestamp returned %v", err)
+	}
+	if _, err := h.GetOrders(huobi.OrdersRequestParams{Symbol: "btcusdt", States: "submitted"}); err == nil {
+		t.Fatal("GetOrders with a stale timestamp succeeded")
+	}
+	if len(rest.Orders()) != 0 {
+		t.Fatal("order created with a stale timestamp")
+	}
+}
+
+// 私有推送不可用时轮询REST接口, created和canceling不产生事件, 也不让订单倒退
+func TestOrderManagerPolling(t *testing.T) {
+	h, rest := newRestHuobi(t, huobi.WithPrivateEndpoint("ws://127.0.0.1:1/ws/v2"))
+	om := h.Orders()
+	om.PollInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := om.Events(ctx, huobi.StreamConfig{Policy: huobi.OverflowBlock})
+	go om.Run(ctx)
+
+	id, err := h.Place(2, 10, "btcusdt", "buy-limit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.ParseInt(id, 10, 64)
+	next := func() *huobi.OrderEvent {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatal("no order event")
+			return nil
+		}
+	}
+
+	rest.FillOrder(n, 0.5)
+	if e := next(); e.Type != huobi.OrderEventFill || e.FillVolume != 0.5 || e.Order.State != huobi.OrderPartialFilled {
+		t.Fatalf("got %v event %+v", e.Type, e.Order)
+	}
+
+	rest.SetOrderState(n, "canceling")
+	time.Sleep(50 * time.Millisecond)
+	rest.SetOrderState(n, "created")
+	time.Sleep(50 * time.Millisecond)
+	if o, _ := om.Order(id); o.State != huobi.OrderPartialFilled {
+		t.Fatalf("order state %s, want %s", o.State, huobi.OrderPartialFilled)
+	}
+
+	rest.SetOrderState(n, "partial-canceled")
+	if e := next(); e.Type != huobi.OrderEventCancel || e.Order.State != huobi.OrderPartialCanceled || e.Order.Filled != 0.5 {
+		t.Fatalf("got %v event %+v", e.Type, e.Order)
+	}
+	if len(om.OpenOrders("")) != 0 {
+		t.Fatal("canceled order still open")
+	}
+}
@@ -1,7 +1,9 @@
 package huobi
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,104 +13,234 @@ var SafeWebSocketDestroyError = fmt.Errorf("connection destroy by user")
 
 // SafeWebSocket 安全的WebSocket封装
 // 保证读取和发送操作是并发安全的，支持自定义保持alive函数
+// 连接出错或被关闭后, 所有后台协程都会退出, Send返回错误
 type SafeWebSocket struct {
-	ws               *websocket.Conn
-	listener         SafeWebSocketMessageListener
-	aliveHandler     SafeWebSocketAliveHandler
-	aliveInterval    time.Duration
-	sendQueue        chan []byte
-	lastError        error
-	runningTaskSend  bool
-	runningTaskRead  bool
-	runningTaskAlive bool
+	ws        *websocket.Conn
+	sendQueue chan []byte
+
+	// ctx在连接出错或被关闭时取消
+	ctx    context.Context
+	cancel context.CancelFunc
+	// 所有后台协程退出后关闭
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	// 第一个导致连接结束的错误
+	err     error
+	errOnce sync.Once
+	// 关闭底层连接的结果
+	closeErr error
+
+	listener      SafeWebSocketMessageListener
+	aliveHandler  SafeWebSocketAliveHandler
+	aliveInterval time.Duration
+	aliveChanged  chan struct{}
+	reading       bool
+	mutex         sync.Mutex
+
+	logger Logger
 }
 
 type SafeWebSocketMessageListener = func(b []byte)
 type SafeWebSocketAliveHandler = func()
 
 // NewSafeWebSocket 创建安全的WebSocket实例并连接
-func NewSafeWebSocket(endpoint string) (*SafeWebSocket, error) {
-	ws, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+func NewSafeWebSocket(endpoint string, opts ...Option) (*SafeWebSocket, error) {
+	o := newOptions(opts)
+	return newSafeWebSocket(endpoint, o.network.websocketDialer(), o.logger)
+}
+
+// newSafeWebSocket 使用dialer连接并创建SafeWebSocket实例
+func newSafeWebSocket(endpoint string, dialer *websocket.Dialer, logger Logger) (*SafeWebSocket, error) {
+	ws, _, err := dialer.Dial(endpoint, nil)
 	if err != nil {
+		logger.Error("websocket dial failed", "endpoint", endpoint, "err", err)
 		return nil, err
 	}
-	s := &SafeWebSocket{ws: ws, sendQueue: make(chan []byte, 1000), aliveInterval: time.Second * 60}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &SafeWebSocket{
+		ws:            ws,
+		sendQueue:     make(chan []byte, 1000),
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		aliveInterval: time.Second * 60,
+		aliveChanged:  make(chan struct{}, 1),
+		logger:        logger,
+	}
+
+	s.wg.Add(3)
+	go s.closeLoop()
+	go s.sendLoop()
+	go s.aliveLoop()
 
 	go func() {
-		s.runningTaskSend = true
-		for s.lastError == nil {
-			b := <-s.sendQueue
+		s.wg.Wait()
+		close(s.done)
+	}()
+
+	return s, nil
+}
+
+// fail 记录第一个错误并通知所有协程退出
+func (s *SafeWebSocket) fail(err error) {
+	s.errOnce.Do(func() {
+		s.mutex.Lock()
+		s.err = err
+		s.mutex.Unlock()
+		s.cancel()
+	})
+}
+
+// Err 导致连接结束的错误, 连接正常时返回nil
+func (s *SafeWebSocket) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// closeLoop 连接结束后关闭底层连接, 使阻塞中的读取返回
+func (s *SafeWebSocket) closeLoop() {
+	defer s.wg.Done()
+	<-s.ctx.Done()
+	err := s.ws.Close()
+	s.mutex.Lock()
+	s.closeErr = err
+	s.mutex.Unlock()
+}
+
+// sendLoop 依次发送队列中的消息
+func (s *SafeWebSocket) sendLoop() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case b := <-s.sendQueue:
 			if err := s.ws.WriteMessage(websocket.TextMessage, b); err != nil {
-				s.lastError = err
-				break
+				s.logger.Warn("websocket write failed", "err", err)
+				s.fail(err)
+				return
 			}
 		}
-		s.runningTaskSend = false
-	}()
+	}
+}
 
-	go func() {
-		s.runningTaskRead = true
-		for s.lastError == nil {
-			_, b, err := s.ws.ReadMessage()
-			if err != nil {
-				s.lastError = err
-				break
+// readLoop 读取消息并交给监听器
+func (s *SafeWebSocket) readLoop() {
+	defer s.wg.Done()
+	for {
+		_, b, err := s.ws.ReadMessage()
+		if err != nil {
+			// 主动关闭导致的读取失败不是连接错误
+			if s.ctx.Err() == nil {
+				s.logger.Warn("websocket read failed", "err", err)
 			}
-			s.listener(b)
+			s.fail(err)
+			return
 		}
-		s.runningTaskRead = false
-	}()
 
-	go func() {
-		s.runningTaskAlive = true
-		for s.lastError == nil {
-			if s.aliveHandler != nil {
-				s.aliveHandler()
-			}
-			time.Sleep(s.aliveInterval)
+		s.mutex.Lock()
+		listener := s.listener
+		s.mutex.Unlock()
+		listener(b)
+	}
+}
+
+// aliveLoop 周期性调用alive函数
+func (s *SafeWebSocket) aliveLoop() {
+	defer s.wg.Done()
+	for {
+		s.mutex.Lock()
+		handler, interval := s.aliveHandler, s.aliveInterval
+		s.mutex.Unlock()
+
+		if handler != nil {
+			handler()
 		}
-		s.runningTaskAlive = false
-	}()
 
-	return s, nil
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.aliveChanged:
+		case <-time.After(interval):
+		}
+	}
 }
 
-// Listen 监听消息
+// Listen 监听消息, 首次调用后才开始读取消息, 之后再调用只替换监听器
 func (s *SafeWebSocket) Listen(h SafeWebSocketMessageListener) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.listener = h
+
+	// closeLoop退出前需要获取mutex, 因此这里Add时计数一定大于0
+	if !s.reading && s.ctx.Err() == nil {
+		s.reading = true
+		s.wg.Add(1)
+		go s.readLoop()
+	}
 }
 
-// Send 发送消息
-func (s *SafeWebSocket) Send(b []byte) {
-	s.sendQueue <- b
+// Send 发送消息, 连接已结束时返回导致结束的错误
+func (s *SafeWebSocket) Send(b []byte) error {
+	select {
+	case <-s.ctx.Done():
+		return s.Err()
+	default:
+	}
+
+	select {
+	case <-s.ctx.Done():
+		return s.Err()
+	case s.sendQueue <- b:
+		return nil
+	}
 }
 
 // KeepAlive 设置alive周期及函数
 func (s *SafeWebSocket) KeepAlive(v time.Duration, h SafeWebSocketAliveHandler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.aliveInterval = v
 	s.aliveHandler = h
-}
 
-// Destroy 销毁
-func (s *SafeWebSocket) Destroy() (err error) {
-	s.lastError = SafeWebSocketDestroyError
-	for !s.runningTaskRead && !s.runningTaskSend && !s.runningTaskAlive {
-		time.Sleep(time.Millisecond * 100)
+	// 立即按新的设置执行, 不必等待上一个周期结束
+	select {
+	case s.aliveChanged <- struct{}{}:
+	default:
 	}
-	if s.ws != nil {
-		err = s.ws.Close()
-		s.ws = nil
+}
+
+// Close 关闭连接并等待所有后台协程退出, ctx结束时不再等待并返回ctx.Err()
+// 不能在监听器或alive函数中以没有期限的ctx调用, 否则会等待自身退出
+func (s *SafeWebSocket) Close(ctx context.Context) error {
+	s.fail(SafeWebSocketDestroyError)
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	s.listener = nil
-	s.aliveHandler = nil
-	s.sendQueue = nil
-	return err
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closeErr
+}
+
+// Destroy 销毁, 等待所有后台协程退出
+func (s *SafeWebSocket) Destroy() error {
+	return s.Close(context.Background())
+}
+
+// Done 所有后台协程退出后关闭
+func (s *SafeWebSocket) Done() <-chan struct{} {
+	return s.done
 }
 
 // Loop 进入事件循环，直到连接关闭才退出
 func (s *SafeWebSocket) Loop() error {
-	for s.lastError == nil {
-		time.Sleep(time.Millisecond * 100)
-	}
-	return s.lastError
+	<-s.ctx.Done()
+	return s.Err()
 }
@@ -0,0 +1,161 @@
+package huobi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/leek-box/sheep/huobi"
+)
+
+// wsServer 记录收到的消息数, 并把每个连接交给onConnect
+type wsServer struct {
+	*httptest.Server
+	received int64
+}
+
+func newWSServer(t *testing.T, onConnect func(c *websocket.Conn)) *wsServer {
+	s := &wsServer{}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		if onConnect != nil {
+			onConnect(c)
+		}
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+			atomic.AddInt64(&s.received, 1)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *wsServer) wsURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func dialSafeWebSocket(t *testing.T, s *wsServer) *huobi.SafeWebSocket {
+	ws, err := huobi.NewSafeWebSocket(s.wsURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func TestSafeWebSocketConcurrentSend(t *testing.T) {
+	s := newWSServer(t, nil)
+	ws := dialSafeWebSocket(t, s)
+	defer ws.Destroy()
+
+	const senders, messages = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				if err := ws.Send([]byte(`{"ping":1}`)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&s.received) < senders*messages {
+		if time.Now().After(deadline) {
+			t.Fatalf("server received %d messages, want %d", atomic.LoadInt64(&s.received), senders*messages)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSafeWebSocketCloseDeadline(t *testing.T) {
+	s := newWSServer(t, func(c *websocket.Conn) {
+		c.WriteMessage(websocket.TextMessage, []byte("hello"))
+	})
+	ws := dialSafeWebSocket(t, s)
+
+	// 在监听器中关闭时等待的是自身, 只能等到ctx结束
+	result := make(chan error, 1)
+	ws.Listen(func(b []byte) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		result <- ws.Close(ctx)
+	})
+
+	select {
+	case err := <-result:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Close in listener returned %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close in listener did not return")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ws.Close(ctx); err != nil {
+		t.Fatalf("Close returned %v", err)
+	}
+	select {
+	case <-ws.Done():
+	default:
+		t.Fatal("Done not closed after Close")
+	}
+}
+
+func TestSafeWebSocketServerClose(t *testing.T) {
+	s := newWSServer(t, func(c *websocket.Conn) {
+		c.Close()
+	})
+	ws := dialSafeWebSocket(t, s)
+	ws.Listen(func(b []byte) {})
+
+	select {
+	case <-ws.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done not closed after the server closed the connection")
+	}
+	if err := ws.Err(); err == nil || err == huobi.SafeWebSocketDestroyError {
+		t.Fatalf("Err() = %v, want a connection error", err)
+	}
+	if err := ws.Loop(); err != ws.Err() {
+		t.Fatalf("Loop() = %v, want %v", err, ws.Err())
+	}
+	if err := ws.Send([]byte("ping")); err == nil {
+		t.Fatal("Send on a connection closed by the server succeeded")
+	}
+}
+
+func TestSafeWebSocketSendAfterClose(t *testing.T) {
+	s := newWSServer(t, nil)
+	ws := dialSafeWebSocket(t, s)
+
+	if err := ws.Destroy(); err != nil {
+		t.Fatalf("Destroy returned %v", err)
+	}
+	if err := ws.Send([]byte("ping")); err != huobi.SafeWebSocketDestroyError {
+		t.Fatalf("Send after close returned %v, want %v", err, huobi.SafeWebSocketDestroyError)
+	}
+	// 重复关闭不报错
+	if err := ws.Destroy(); err != nil {
+		t.Fatalf("second Destroy returned %v", err)
+	}
+}
@@ -1,28 +1,146 @@
 package huobi
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"net/url"
 	"sort"
+	"time"
 )
 
+// Signer 请求签名器
+// 签名所需的密钥由实现方持有, 调用方只提交待签名的内容
+type Signer interface {
+	// SignatureMethod 签名方法, 作为SignatureMethod参数提交
+	SignatureMethod() string
+	// Sign 对payload签名
+	// return: BASE64编码的签名
+	Sign(payload string) (string, error)
+}
+
+// HmacSigner 使用HmacSHA256和Secret Key签名
+type HmacSigner struct {
+	secretKey string
+}
+
+// NewHmacSigner 创建HmacSHA256签名器
+func NewHmacSigner(secretKey string) *HmacSigner {
+	return &HmacSigner{secretKey: secretKey}
+}
+
+func (s *HmacSigner) SignatureMethod() string {
+	return "HmacSHA256"
+}
+
+func (s *HmacSigner) Sign(payload string) (string, error) {
+	return computeHmac256(payload, s.secretKey), nil
+}
+
+// ECDSACurveError ECDSA私钥不在P-256曲线上
+var ECDSACurveError = errors.New("ecdsa private key is not on curve P-256")
+
+// ECDSASigner 使用ECDSA私钥签名, 对应火币的私钥签名方式
+// 签名对payload的SHA256摘要进行, 结果为ASN.1 DER编码
+type ECDSASigner struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewECDSASigner 创建ECDSA签名器
+// 火币只支持P-256曲线(prime256v1, 即secp256r1), 不是secp256k1, 从外部加载的私钥应使用ParseECDSASigner校验
+func NewECDSASigner(key *ecdsa.PrivateKey) *ECDSASigner {
+	return &ECDSASigner{key: key}
+}
+
+// ParseECDSASigner 从PEM格式的私钥创建ECDSA签名器, 支持SEC1(EC PRIVATE KEY)和PKCS#8格式
+// 私钥不在P-256曲线上时返回ECDSACurveError
+func ParseECDSASigner(pemBytes []byte) (*ECDSASigner, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("invalid pem private key")
+	}
+
+	ecKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if ecKey, ok = key.(*ecdsa.PrivateKey); !ok {
+			return nil, errors.New("private key is not ecdsa")
+		}
+	}
+	if ecKey.Curve != elliptic.P256() {
+		return nil, ECDSACurveError
+	}
+
+	return NewECDSASigner(ecKey), nil
+}
+
+func (s *ECDSASigner) SignatureMethod() string {
+	return "ECDSA"
+}
+
+func (s *ECDSASigner) Sign(payload string) (string, error) {
+	digest := sha256.Sum256([]byte(payload))
+	sig, err := ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
 // 构造签名
 // mapParams: 送进来参与签名的参数, Map类型
 // strMethod: 请求的方法 GET, POST......
 // strHostUrl: 请求的主机
 // strRequestPath: 请求的路由路径
-// strSecretKey: 进行签名的密钥
-func createSign(mapParams map[string]string, strMethod, strHostUrl, strRequestPath, strSecretKey string) string {
+// signer: 签名器
+func createSign(mapParams map[string]string, strMethod, strHostUrl, strRequestPath string, signer Signer) (string, error) {
+	return signer.Sign(SignaturePayload(mapParams, strMethod, strHostUrl, strRequestPath))
+}
+
+// SignaturePayload 构造待签名的内容, 服务端可用它校验签名
+// mapParams: 参与签名的参数, 不含Signature, 不会被修改
+// strMethod: 请求的方法 GET, POST......
+// strHostUrl: 请求的主机
+// strRequestPath: 请求的路由路径
+func SignaturePayload(mapParams map[string]string, strMethod, strHostUrl, strRequestPath string) string {
 	// 参数处理, 按API要求, 参数名应按ASCII码进行排序(使用UTF-8编码, 其进行URI编码, 16进制字符必须大写)
 	sortedParams := MapSortByKey(mapParams)
 	encodeParams := mapValueEncodeURI(sortedParams)
 	strParams := map2UrlQuery(encodeParams)
 
-	strPayload := strMethod + "\n" + strHostUrl + "\n" + strRequestPath + "\n" + strParams
+	return strMethod + "\n" + strHostUrl + "\n" + strRequestPath + "\n" + strParams
+}
+
+// 构造v2 websocket鉴权参数, 签名版本2.1
+// credentials: 访问凭证
+// strHostUrl: websocket的主机
+// strRequestPath: websocket的路由路径, 如/ws/v2
+func createWebsocketAuthParams(credentials *Credentials, strHostUrl, strRequestPath string) (map[string]string, error) {
+	mapParams := make(map[string]string)
+	mapParams["accessKey"] = credentials.AccessKey
+	mapParams["signatureMethod"] = credentials.Signer.SignatureMethod()
+	mapParams["signatureVersion"] = "2.1"
+	mapParams["timestamp"] = time.Now().UTC().Format("2006-01-02T15:04:05")
+
+	signature, err := createSign(mapParams, "GET", strHostUrl, strRequestPath, credentials.Signer)
+	if err != nil {
+		return nil, err
+	}
+	mapParams["authType"] = "api"
+	mapParams["signature"] = signature
 
-	return computeHmac256(strPayload, strSecretKey)
+	return mapParams, nil
 }
 
 // 对Map的值进行URI编码
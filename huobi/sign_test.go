@@ -0,0 +1,110 @@
+package huobi_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"github.com/leek-box/sheep/huobi"
+)
+
+// 火币API文档中签名示例的参数
+var signParams = map[string]string{
+	"AccessKeyId":      "e2xxxxxx-99xxxxxx-84xxxxxx-7xxxx",
+	"SignatureMethod":  "HmacSHA256",
+	"SignatureVersion": "2",
+	"Timestamp":        "2017-05-11T15:19:30",
+	"order-id":         "1234567890",
+}
+
+const signPayload = "GET\napi.huobi.pro\n/v1/order/orders\n" +
+	"AccessKeyId=e2xxxxxx-99xxxxxx-84xxxxxx-7xxxx&SignatureMethod=HmacSHA256&SignatureVersion=2" +
+	"&Timestamp=2017-05-11T15%3A19%3A30&order-id=1234567890"
+
+func TestSignaturePayload(t *testing.T) {
+	payload := huobi.SignaturePayload(signParams, "GET", "api.huobi.pro", "/v1/order/orders")
+	if payload != signPayload {
+		t.Fatalf("payload = %q, want %q", payload, signPayload)
+	}
+	if signParams["Timestamp"] != "2017-05-11T15:19:30" {
+		t.Fatal("SignaturePayload modified its params")
+	}
+}
+
+func TestHmacSigner(t *testing.T) {
+	signer := huobi.NewHmacSigner("b0xxxxxx-c6xxxxxx-94xxxxxx-dxxxx")
+	if method := signer.SignatureMethod(); method != "HmacSHA256" {
+		t.Fatalf("SignatureMethod() = %q", method)
+	}
+
+	signature, err := signer.Sign(signPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "Nmd8AU8uAe0mkFpxNbiava0aeZzBEtYjCdie1ZYZjoM="
+	if signature != want {
+		t.Fatalf("signature = %q, want %q", signature, want)
+	}
+}
+
+func TestECDSASigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := huobi.ParseECDSASigner(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method := signer.SignatureMethod(); method != "ECDSA" {
+		t.Fatalf("SignatureMethod() = %q", method)
+	}
+
+	signature, err := signer.Sign(signPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte(signPayload))
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Fatal("signature does not verify with the public key")
+	}
+	tampered := sha256.Sum256([]byte(signPayload + "&x=1"))
+	if ecdsa.VerifyASN1(&key.PublicKey, tampered[:], sig) {
+		t.Fatal("signature verifies for a different payload")
+	}
+}
+
+func TestECDSASignerRejectsOtherCurves(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range []*pem.Block{{Type: "EC PRIVATE KEY", Bytes: sec1}, {Type: "PRIVATE KEY", Bytes: pkcs8}} {
+		if _, err := huobi.ParseECDSASigner(pem.EncodeToMemory(block)); err != huobi.ECDSACurveError {
+			t.Fatalf("%s on P-384: err = %v, want %v", block.Type, err, huobi.ECDSACurveError)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package huobi
+
+import (
+	"context"
+	"sync"
+)
+
+// OverflowPolicy 流的缓冲区满时的处理策略
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest 丢弃缓冲区中最旧的消息, 默认策略
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowBlock 阻塞直到消费者读取, 会同时阻塞websocket上所有主题的推送, 需显式指定
+	OverflowBlock
+	// OverflowDropNewest 丢弃新到的消息
+	OverflowDropNewest
+	// OverflowKeepLatest 只保留最新的一条消息, 忽略Buffer
+	OverflowKeepLatest
+)
+
+// StreamConfig 流的缓冲配置
+type StreamConfig struct {
+	Buffer int                  // 缓冲大小, 默认64
+	Policy OverflowPolicy       // 缓冲区满时的策略, 默认OverflowDropOldest
+	OnDrop func(dropped uint64) // 丢弃消息时回调, 参数为累计丢弃数, 在websocket读协程中调用
+
+	// OnError 订阅出错时回调, 如重连后重新订阅失败、消息解析失败, 之后流被关闭
+	OnError func(err error)
+}
+
+const defaultStreamBuffer = 64
+
+// streamBuffer 在websocket读协程和消费者之间缓冲消息
+type streamBuffer struct {
+	topic   string
+	config  StreamConfig
+	metrics Metrics
+
+	items   []interface{}
+	closed  bool
+	dropped uint64
+
+	mutex sync.Mutex
+	cond  *sync.Cond
+}
+
+func newStreamBuffer(topic string, metrics Metrics, config []StreamConfig) *streamBuffer {
+	b := &streamBuffer{topic: topic, metrics: metrics}
+	if len(config) > 0 {
+		b.config = config[0]
+	}
+	if b.config.Buffer <= 0 {
+		b.config.Buffer = defaultStreamBuffer
+	}
+	if b.config.Policy == OverflowKeepLatest {
+		b.config.Buffer = 1
+	}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+// push 写入消息, 缓冲区满时按策略处理
+func (b *streamBuffer) push(v interface{}) {
+	b.mutex.Lock()
+
+	for !b.closed && len(b.items) >= b.config.Buffer && b.config.Policy == OverflowBlock {
+		b.cond.Wait()
+	}
+	if b.closed {
+		b.mutex.Unlock()
+		return
+	}
+
+	dropped := false
+	if len(b.items) >= b.config.Buffer {
+		dropped = true
+		switch b.config.Policy {
+		case OverflowDropOldest, OverflowKeepLatest:
+			copy(b.items, b.items[1:])
+			b.items[len(b.items)-1] = v
+		case OverflowDropNewest:
+			// 直接丢弃v
+		}
+	} else {
+		b.items = append(b.items, v)
+		b.cond.Broadcast()
+	}
+
+	var total uint64
+	if dropped {
+		b.dropped++
+		total = b.dropped
+	}
+	b.mutex.Unlock()
+
+	if dropped {
+		b.metrics.IncStreamDrop(b.topic)
+		if b.config.OnDrop != nil {
+			b.config.OnDrop(total)
+		}
+	}
+}
+
+// pop 取出最早的消息, 缓冲区为空时等待, 关闭后返回false
+func (b *streamBuffer) pop() (interface{}, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for !b.closed && len(b.items) == 0 {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return nil, false
+	}
+
+	v := b.items[0]
+	b.items[0] = nil
+	b.items = b.items[1:]
+	b.cond.Broadcast()
+	return v, true
+}
+
+// close 关闭缓冲区, 唤醒所有等待中的读写
+func (b *streamBuffer) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	b.items = nil
+	b.cond.Broadcast()
+}
+
+// runStream 将缓冲区中的消息依次交给send, ctx结束或errc关闭后停止写入并调用stop, 并调用done关闭输出通道
+// errc为订阅句柄的Err(), 收到的第一个错误交给OnError并结束流, 句柄被外部关闭时随之结束, 不需要时传nil
+// 交给send的ctx在流结束时取消, send阻塞时需同时等待它
+func runStream(ctx context.Context, stop func(), errc <-chan error, buf *streamBuffer, send func(ctx context.Context, v interface{}) bool, done func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer func() {
+			cancel()
+			// 先关闭缓冲区唤醒阻塞在push中的websocket读协程, 否则stop等待的unsubbed确认无法被读取
+			buf.close()
+			stop()
+		}()
+		select {
+		case <-ctx.Done():
+		case err, ok := <-errc:
+			if ok && buf.config.OnError != nil {
+				buf.config.OnError(err)
+			}
+		}
+	}()
+
+	go func() {
+		defer done()
+		for {
+			v, ok := buf.pop()
+			if !ok {
+				return
+			}
+			if !send(ctx, v) {
+				return
+			}
+		}
+	}()
+}
+
+// DepthStream 以通道形式订阅深度, ctx结束后取消订阅并关闭通道
+func (h *Huobi) DepthStream(ctx context.Context, symbol string, option DepthOption, config ...StreamConfig) (<-chan *MarketDepth, error) {
+	topic, err := option.topic(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := newStreamBuffer(topic, h.metrics, config)
+	sub, err := h.SubscribeDepthFunc(symbol, option, func(symbol string, depth *MarketDepth) {
+		buf.push(depth)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *MarketDepth)
+	runStream(ctx, func() { sub.Unsubscribe() }, sub.Err(), buf, func(ctx context.Context, v interface{}) bool {
+		select {
+		case out <- v.(*MarketDepth):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() { close(out) })
+
+	return out, nil
+}
+
+// DetailStream 以通道形式订阅成交明细, ctx结束后取消订阅并关闭通道
+func (h *Huobi) DetailStream(ctx context.Context, symbol string, config ...StreamConfig) (<-chan *MarketTradeDetail, error) {
+	buf := newStreamBuffer("market."+symbol+".trade.detail", h.metrics, config)
+	sub, err := h.SubscribeDetailFunc(symbol, func(symbol string, detail *MarketTradeDetail) {
+		buf.push(detail)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *MarketTradeDetail)
+	runStream(ctx, func() { sub.Unsubscribe() }, sub.Err(), buf, func(ctx context.Context, v interface{}) bool {
+		select {
+		case out <- v.(*MarketTradeDetail):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() { close(out) })
+
+	return out, nil
+}
+
+// KlineUpdate K线流中的一条推送, 含义同KlineListener的参数
+type KlineUpdate struct {
+	Symbol string
+	Period Period
+	Kline  *Kline
+	Closed bool
+}
+
+// KlineStream 以通道形式订阅K线, ctx结束后取消订阅并关闭通道
+// 使用丢弃策略时, closed为true的推送也可能被丢弃
+func (h *Huobi) KlineStream(ctx context.Context, symbol string, period Period, config ...StreamConfig) (<-chan *KlineUpdate, error) {
+	buf := newStreamBuffer("market."+symbol+".kline."+string(period), h.metrics, config)
+	sub, err := h.SubscribeKline(symbol, period, func(symbol string, period Period, kline *Kline, closed bool) {
+		buf.push(&KlineUpdate{Symbol: symbol, Period: period, Kline: kline, Closed: closed})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *KlineUpdate)
+	runStream(ctx, func() { sub.Unsubscribe() }, sub.Err(), buf, func(ctx context.Context, v interface{}) bool {
+		select {
+		case out <- v.(*KlineUpdate):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() { close(out) })
+
+	return out, nil
+}
+
+// BBOStream 以通道形式订阅买一卖一, ctx结束后取消订阅并关闭通道
+func (h *Huobi) BBOStream(ctx context.Context, symbol string, config ...StreamConfig) (<-chan *BBO, error) {
+	buf := newStreamBuffer("market."+symbol+".bbo", h.metrics, config)
+	sub, err := h.SubscribeBBO(symbol, func(symbol string, bbo *BBO) {
+		buf.push(bbo)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *BBO)
+	runStream(ctx, func() { sub.Unsubscribe() }, sub.Err(), buf, func(ctx context.Context, v interface{}) bool {
+		select {
+		case out <- v.(*BBO):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() { close(out) })
+
+	return out, nil
+}
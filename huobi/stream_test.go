@@ -0,0 +1,211 @@
+package huobi
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dropMetrics 统计流丢弃的消息数
+type dropMetrics struct {
+	nopMetrics
+	drops int64
+}
+
+func (m *dropMetrics) IncStreamDrop(topic string) {
+	atomic.AddInt64(&m.drops, 1)
+}
+
+// drain 取出缓冲区中的全部消息
+func drain(b *streamBuffer) []interface{} {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	items := b.items
+	b.items = nil
+	return items
+}
+
+func TestStreamBufferPolicies(t *testing.T) {
+	cases := []struct {
+		policy  OverflowPolicy
+		want    []interface{}
+		dropped uint64
+	}{
+		{OverflowDropOldest, []interface{}{3, 4, 5}, 2},
+		{OverflowDropNewest, []interface{}{1, 2, 3}, 2},
+		{OverflowKeepLatest, []interface{}{5}, 4},
+	}
+	for _, c := range cases {
+		metrics := &dropMetrics{}
+		var totals []uint64
+		b := newStreamBuffer("topic", metrics, []StreamConfig{{
+			Buffer: 3,
+			Policy: c.policy,
+			OnDrop: func(dropped uint64) { totals = append(totals, dropped) },
+		}})
+		for i := 1; i <= 5; i++ {
+			b.push(i)
+		}
+
+		got := drain(b)
+		if len(got) != len(c.want) {
+			t.Fatalf("policy %d: buffered %v, want %v", c.policy, got, c.want)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("policy %d: buffered %v, want %v", c.policy, got, c.want)
+			}
+		}
+		if metrics.drops != int64(c.dropped) {
+			t.Fatalf("policy %d: IncStreamDrop called %d times, want %d", c.policy, metrics.drops, c.dropped)
+		}
+		if len(totals) != int(c.dropped) || totals[len(totals)-1] != c.dropped {
+			t.Fatalf("policy %d: OnDrop totals %v, want 1..%d", c.policy, totals, c.dropped)
+		}
+	}
+}
+
+func TestStreamBufferBlock(t *testing.T) {
+	b := newStreamBuffer("topic", &dropMetrics{}, []StreamConfig{{Buffer: 1, Policy: OverflowBlock}})
+	b.push(1)
+
+	pushed := make(chan struct{})
+	go func() {
+		b.push(2)
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("push did not block on a full buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if v, ok := b.pop(); !ok || v != 1 {
+		t.Fatalf("pop = %v, %v", v, ok)
+	}
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push still blocked after pop")
+	}
+	if v, ok := b.pop(); !ok || v != 2 {
+		t.Fatalf("pop = %v, %v", v, ok)
+	}
+	if b.dropped != 0 {
+		t.Fatalf("dropped %d messages with OverflowBlock", b.dropped)
+	}
+}
+
+// startStream 启动一个int流, 返回输出通道和stop被调用的通知
+func startStream(ctx context.Context, buf *streamBuffer, errc <-chan error, stop func()) (<-chan int, <-chan struct{}) {
+	out := make(chan int)
+	stopped := make(chan struct{})
+	runStream(ctx, func() {
+		stop()
+		close(stopped)
+	}, errc, buf, func(ctx context.Context, v interface{}) bool {
+		select {
+		case out <- v.(int):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() { close(out) })
+	return out, stopped
+}
+
+func TestStreamCancelWithFullBlockBuffer(t *testing.T) {
+	buf := newStreamBuffer("topic", &dropMetrics{}, []StreamConfig{{Buffer: 1, Policy: OverflowBlock}})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// 模拟websocket读协程: 消费者离开后, 后续推送阻塞在push中
+	pushed := make(chan struct{})
+	go func() {
+		for i := 1; i <= 5; i++ {
+			buf.push(i)
+		}
+		close(pushed)
+	}()
+
+	// stop对应取消订阅, 需要读协程继续运行才能收到unsubbed确认
+	out, stopped := startStream(ctx, buf, nil, func() {
+		select {
+		case <-pushed:
+		case <-time.After(time.Second):
+			t.Error("websocket reader still blocked in push while unsubscribing")
+		}
+	})
+	// 消费者读取一条后离开
+	if v := <-out; v != 1 {
+		t.Fatalf("received %d, want 1", v)
+	}
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stop not called after cancel")
+	}
+	for range out {
+	}
+}
+
+func TestStreamTerminalError(t *testing.T) {
+	errStream := errors.New("resubscribe failed")
+	var got error
+	buf := newStreamBuffer("topic", &dropMetrics{}, []StreamConfig{{OnError: func(err error) { got = err }}})
+	errc := make(chan error, 1)
+
+	out, stopped := startStream(context.Background(), buf, errc, func() {})
+	buf.push(1)
+	if v := <-out; v != 1 {
+		t.Fatalf("received %d, want 1", v)
+	}
+
+	// 消费者此时不读取, 出错后流也要结束
+	buf.push(2)
+	errc <- errStream
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stop not called after subscription error")
+	}
+	if got != errStream {
+		t.Fatalf("OnError got %v, want %v", got, errStream)
+	}
+	select {
+	case _, ok := <-out:
+		if ok {
+			// 已取出的消息可能先送达
+			if _, ok := <-out; ok {
+				t.Fatal("stream still open after subscription error")
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream not closed after subscription error")
+	}
+}
+
+func TestStreamSubscriptionClosed(t *testing.T) {
+	buf := newStreamBuffer("topic", &dropMetrics{}, nil)
+	errc := make(chan error)
+	var called int32
+	buf.config.OnError = func(err error) { atomic.AddInt32(&called, 1) }
+
+	out, stopped := startStream(context.Background(), buf, errc, func() {})
+	close(errc)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stop not called after the subscription was closed")
+	}
+	if _, ok := <-out; ok {
+		t.Fatal("stream still open after the subscription was closed")
+	}
+	if atomic.LoadInt32(&called) != 0 {
+		t.Fatal("OnError called for a closed subscription")
+	}
+}
@@ -0,0 +1,190 @@
+package huobi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// SubscribeTimeoutError 等待订阅结果超时
+var SubscribeTimeoutError = errors.New("subscribe timeout")
+
+// UnsubscribeTimeoutError 等待取消订阅结果超时
+var UnsubscribeTimeoutError = errors.New("unsubscribe timeout")
+
+// UnsubscribedError 订阅结果返回前主题已被取消订阅
+var UnsubscribedError = errors.New("topic unsubscribed")
+
+// subscriptionOwner 创建订阅句柄的Market或MarketPool
+type subscriptionOwner interface {
+	removeSubscription(sub *Subscription) error
+}
+
+// Subscription 订阅句柄
+// 同一主题可以有多个Subscription, 各自拥有独立的监听器, 最后一个取消时才取消主题订阅
+type Subscription struct {
+	owner subscriptionOwner
+	topic string
+	errc  chan error
+	once  sync.Once
+}
+
+func newSubscription(owner subscriptionOwner, topic string) *Subscription {
+	return &Subscription{owner: owner, topic: topic, errc: make(chan error, 16)}
+}
+
+// Topic 订阅的主题
+func (s *Subscription) Topic() string {
+	return s.topic
+}
+
+// Err 订阅过程中的错误, 如重连后重新订阅失败, 消息解析失败等
+// 错误来不及读取时会被丢弃, 取消订阅后关闭
+func (s *Subscription) Err() <-chan error {
+	return s.errc
+}
+
+// Unsubscribe 取消订阅, 可重复调用
+func (s *Subscription) Unsubscribe() error {
+	return s.owner.removeSubscription(s)
+}
+
+// reportError 投递错误, 不阻塞, 调用方需持有所在Market的mutex
+func (s *Subscription) reportError(err error) {
+	select {
+	case s.errc <- err:
+	default:
+	}
+}
+
+// close 关闭错误通道, 调用方需持有所在Market的mutex
+func (s *Subscription) close() {
+	s.once.Do(func() {
+		close(s.errc)
+	})
+}
+
+// subscribeAck 一次订阅指令的结果, 同一主题并发订阅时共享
+type subscribeAck struct {
+	topic string
+	err   error
+	done  chan struct{}
+	once  sync.Once
+
+	// 超时定时器, 可能在设置之前就触发, 因此需要加锁
+	timer      *time.Timer
+	timerMutex sync.Mutex
+}
+
+func newSubscribeAck(topic string) *subscribeAck {
+	return &subscribeAck{topic: topic, done: make(chan struct{})}
+}
+
+// subscribedAck 已订阅主题的结果, 始终成功
+var subscribedAck = func() *subscribeAck {
+	ack := newSubscribeAck("")
+	close(ack.done)
+	return ack
+}()
+
+// deadline 在d之后以超时结束
+func (a *subscribeAck) deadline(d time.Duration, timeout func()) {
+	a.timerMutex.Lock()
+	defer a.timerMutex.Unlock()
+	a.timer = time.AfterFunc(d, timeout)
+}
+
+// finish 设置结果, 只有第一次调用生效, cleanup在通知等待者之前执行
+func (a *subscribeAck) finish(err error, cleanup func()) {
+	a.once.Do(func() {
+		a.timerMutex.Lock()
+		if a.timer != nil {
+			a.timer.Stop()
+		}
+		a.timerMutex.Unlock()
+		cleanup()
+		a.err = err
+		close(a.done)
+	})
+}
+
+// SubscribeFuture 异步订阅的结果
+type SubscribeFuture struct {
+	topic string
+	sub   *Subscription
+	err   error
+	done  chan struct{}
+}
+
+func newSubscribeFuture(topic string) *SubscribeFuture {
+	return &SubscribeFuture{topic: topic, done: make(chan struct{})}
+}
+
+// failedSubscribeFuture 返回已失败的结果
+func failedSubscribeFuture(topic string, err error) *SubscribeFuture {
+	f := newSubscribeFuture(topic)
+	f.resolve(nil, err)
+	return f
+}
+
+func (f *SubscribeFuture) resolve(sub *Subscription, err error) {
+	f.sub = sub
+	f.err = err
+	close(f.done)
+}
+
+// Topic 订阅的主题
+func (f *SubscribeFuture) Topic() string {
+	return f.topic
+}
+
+// Done 订阅成功或失败后关闭
+func (f *SubscribeFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result 订阅结果, Done关闭前调用返回nil, nil
+func (f *SubscribeFuture) Result() (*Subscription, error) {
+	select {
+	case <-f.done:
+		return f.sub, f.err
+	default:
+		return nil, nil
+	}
+}
+
+// Wait 等待订阅结果, ctx结束时返回ctx.Err(), 订阅仍在后台继续
+func (f *SubscribeFuture) Wait(ctx context.Context) (*Subscription, error) {
+	select {
+	case <-f.done:
+		return f.sub, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Then 订阅完成后在新的协程中调用fn
+func (f *SubscribeFuture) Then(fn func(sub *Subscription, err error)) {
+	go func() {
+		<-f.done
+		fn(f.sub, f.err)
+	}()
+}
+
+// SubscribeResult SubscribeMany中单个主题的结果
+type SubscribeResult struct {
+	Topic        string
+	Subscription *Subscription
+	Err          error
+}
+
+// waitSubscribeFutures 按顺序收集所有结果
+func waitSubscribeFutures(ctx context.Context, futures []*SubscribeFuture) []SubscribeResult {
+	results := make([]SubscribeResult, len(futures))
+	for i, f := range futures {
+		sub, err := f.Wait(ctx)
+		results[i] = SubscribeResult{Topic: f.topic, Subscription: sub, Err: err}
+	}
+	return results
+}
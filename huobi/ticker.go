@@ -0,0 +1,121 @@
+package huobi
+
+import (
+	"strings"
+)
+
+// BBO 买一卖一
+type BBO struct {
+	Symbol    string  `json:"symbol"`
+	SeqID     int64   `json:"seqId"`
+	Ask       float64 `json:"ask"`       // 卖一价
+	AskSize   float64 `json:"askSize"`   // 卖一量
+	Bid       float64 `json:"bid"`       // 买一价
+	BidSize   float64 `json:"bidSize"`   // 买一量
+	QuoteTime int64   `json:"quoteTime"` // 报价时间, 毫秒
+}
+
+type MarketBBO struct {
+	Ch   string `json:"ch"`
+	TS   int64  `json:"ts"`
+	Tick BBO    `json:"tick"`
+}
+
+// Detail24h 最近24小时成交统计
+type Detail24h struct {
+	ID      int64   `json:"id"`
+	Open    float64 `json:"open"`   // 开盘价
+	Close   float64 `json:"close"`  // 最新价
+	Low     float64 `json:"low"`    // 最低价
+	High    float64 `json:"high"`   // 最高价
+	Amount  float64 `json:"amount"` // 成交量
+	Vol     float64 `json:"vol"`    // 成交额
+	Count   int64   `json:"count"`  // 成交笔数
+	Version int64   `json:"version"`
+}
+
+type MarketDetail24h struct {
+	Ch   string    `json:"ch"`
+	TS   int64     `json:"ts"`
+	Tick Detail24h `json:"tick"`
+}
+
+// Ticker 单个交易对的24小时行情及买一卖一
+type Ticker struct {
+	Symbol  string  `json:"symbol"`
+	Open    float64 `json:"open"`
+	High    float64 `json:"high"`
+	Low     float64 `json:"low"`
+	Close   float64 `json:"close"`
+	Amount  float64 `json:"amount"`
+	Vol     float64 `json:"vol"`
+	Count   int64   `json:"count"`
+	Bid     float64 `json:"bid"`
+	BidSize float64 `json:"bidSize"`
+	Ask     float64 `json:"ask"`
+	AskSize float64 `json:"askSize"`
+}
+
+type MarketTickers struct {
+	Ch   string   `json:"ch"`
+	TS   int64    `json:"ts"`
+	Data []Ticker `json:"data"`
+}
+
+// BBOListener 买一卖一监听器
+type BBOListener = func(symbol string, bbo *BBO)
+
+// SubscribeBBO 订阅买一卖一 market.$symbol.bbo
+func (h *Huobi) SubscribeBBO(symbol string, listener BBOListener) (*Subscription, error) {
+	return h.market.ListenRaw("market."+symbol+".bbo", func(topic string, msg []byte) {
+		var mb MarketBBO
+		if h.decodeTopic(topic, msg, &mb) != nil {
+			return
+		}
+
+		ts := strings.Split(topic, ".")
+		listener(ts[1], &mb.Tick)
+	})
+}
+
+// Detail24hListener 24小时成交统计监听器
+type Detail24hListener = func(symbol string, detail *Detail24h)
+
+// SubscribeDetail24h 订阅24小时成交统计 market.$symbol.detail
+func (h *Huobi) SubscribeDetail24h(symbol string, listener Detail24hListener) (*Subscription, error) {
+	return h.market.ListenRaw("market."+symbol+".detail", func(topic string, msg []byte) {
+		var md MarketDetail24h
+		if h.decodeTopic(topic, msg, &md) != nil {
+			return
+		}
+
+		ts := strings.Split(topic, ".")
+		listener(ts[1], &md.Tick)
+	})
+}
+
+// TickerListener 行情快照监听器
+type TickerListener = func(symbol string, ticker *Ticker)
+
+// SubscribeTickers 订阅所有交易对的行情快照 market.tickers
+// symbols为空时listener接收所有交易对, 否则只接收指定的交易对
+func (h *Huobi) SubscribeTickers(listener TickerListener, symbols ...string) (*Subscription, error) {
+	filter := make(map[string]bool)
+	for _, symbol := range symbols {
+		filter[symbol] = true
+	}
+
+	return h.market.ListenRaw("market.tickers", func(topic string, msg []byte) {
+		var mt MarketTickers
+		if h.decodeTopic(topic, msg, &mt) != nil {
+			return
+		}
+
+		for i := range mt.Data {
+			ticker := &mt.Data[i]
+			if len(filter) == 0 || filter[ticker.Symbol] {
+				listener(ticker.Symbol, ticker)
+			}
+		}
+	})
+}
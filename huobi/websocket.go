@@ -1,23 +1,24 @@
 package huobi
 
 import (
-	"bytes"
-	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"math/rand"
+	"strings"
 	"time"
 
 	"math"
 
 	"github.com/bitly/go-simplejson"
-	"github.com/leizongmin/huobiapi/debug"
+	"github.com/gorilla/websocket"
 	"sync"
+	"sync/atomic"
 )
 
-// Endpoint 行情的Websocket入口
-var Endpoint = "wss://api.huobi.pro/ws"
+// Endpoint 行情的Websocket默认入口, 未通过WithEndpoints设置时使用
+var Endpoint = EndpointDefault
 
 // ConnectionClosedError Websocket未连接错误
 var ConnectionClosedError = fmt.Errorf("websocket connection closed")
@@ -35,12 +36,32 @@ type subData struct {
 	ID  string `json:"id"`
 }
 
+type unsubData struct {
+	Unsub string `json:"unsub"`
+	ID    string `json:"id"`
+}
+
+// unsubIDPrefix 取消订阅指令的id前缀, 用于区分订阅与取消订阅失败的错误消息
+const unsubIDPrefix = "unsub."
+
+// reqIDPrefix 请求指令的id前缀, 用于将请求失败的错误消息交给等待中的Request
+const reqIDPrefix = "req."
+
 type reqData struct {
-	Req string `json:"req"`
-	ID  string `json:"id"`
+	Req  string `json:"req"`
+	ID   string `json:"id"`
+	From int64  `json:"from,omitempty"` // 起始时间, 秒
+	To   int64  `json:"to,omitempty"`   // 结束时间, 秒
 }
 
-type jsonChan = chan *simplejson.Json
+// RequestTimeoutError 等待请求结果超时
+var RequestTimeoutError = errors.New("market request timeout")
+
+// RequestRateLimitError 请求过于频繁, 被服务器拒绝
+var RequestRateLimitError = errors.New("market request rate limited")
+
+// errCodeTooManyRequests 请求频率超限时的err-code
+const errCodeTooManyRequests = "too-many-request"
 
 var letterRunes = []rune("1234567890abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
@@ -58,211 +79,326 @@ func getUinxMillisecond() int64 {
 	return time.Now().UnixNano() / int64(time.Millisecond)
 }
 
-// unGzipData 解压gzip的数据
-func unGzipData(buf []byte) ([]byte, error) {
-	r, err := gzip.NewReader(bytes.NewBuffer(buf))
-	if err != nil {
-		return nil, err
-	}
-	return ioutil.ReadAll(r)
-}
-
 type wsOperation struct {
 	cmd  string
 	data interface{}
 }
 
 type Market struct {
-	ws *SafeWebSocket
+	ws      *SafeWebSocket
+	wsMutex sync.Mutex
 
-	listeners         map[string]Listener
-	subscribedTopic   map[string]bool
-	subscribeResultCb map[string]jsonChan
-	requestResultCb   map[string]jsonChan
+	endpoints *endpointSet
+	dialer    *websocket.Dialer
 
-	// 掉线后是否自动重连，如果用户主动执行Close()则不自动重连
-	autoReconnect bool
+	// 唯一的重连者, 只在Loop中重连
+	supervisor *supervisor
 
-	// 上次接收到的ping时间戳
+	listeners           map[string]map[*Subscription]RawListener
+	subscribedTopic     map[string]bool
+	subscribeResultCb   map[string]*subscribeAck
+	unsubscribeResultCb map[string]replyChan
+	requestResultCb     map[string]replyChan
+
+	// 上次接收到的ping时间戳, 原子访问
 	lastPing int64
 
+	// 重连成功后、重新订阅前调用, MarketPool借此迁移主题
+	onReconnect func(m *Market)
+
 	// 主动发送心跳的时间间隔，默认5秒
 	HeartbeatInterval time.Duration
-	// 接收消息超时时间，默认10秒
+	// 接收消息超时时间，默认10秒, 也是等待订阅、取消订阅及请求结果的期限
 	ReceiveTimeout time.Duration
 
 	mutex *sync.RWMutex
+	// 保证sub/unsub指令按订阅状态变更的顺序发送, 先于mutex获取, 发送时只持有subMutex
+	subMutex sync.Mutex
+
+	logger  Logger
+	metrics Metrics
 }
 
 // Listener 订阅事件监听器
 type Listener = func(topic string, json *simplejson.Json)
 
+// RawListener 订阅事件监听器, msg为解压后的消息原文, 可直接解码为具体类型
+// msg只在回调期间有效, 需要保留时自行复制
+type RawListener = func(topic string, msg []byte)
+
+// jsonListener 将消息解析为simplejson后交给listener
+func (m *Market) jsonListener(listener Listener) RawListener {
+	return func(topic string, msg []byte) {
+		json, err := simplejson.NewJson(msg)
+		if err != nil {
+			m.logger.Error("parse message failed", "topic", topic, "err", err)
+			m.metrics.IncDecodeFailure(topic)
+			m.reportError(topic, err)
+			return
+		}
+		listener(topic, json)
+	}
+}
+
 // NewMarket 创建Market实例
-func NewMarket() (m *Market, err error) {
+func NewMarket(opts ...Option) (m *Market, err error) {
+	o := newOptions(opts)
+	if o.probeEndpoints {
+		o.endpoints = probeEndpoints(o.endpoints, o.network.websocketDialer(), defaultProbeTimeout, o.logger)
+	}
+	return newMarket(o, nil)
+}
+
+// newMarket 创建Market实例, onReconnect在每次重连成功后调用
+func newMarket(o *options, onReconnect func(m *Market)) (m *Market, err error) {
 	m = &Market{
-		HeartbeatInterval: 5 * time.Second,
-		ReceiveTimeout:    10 * time.Second,
-		ws:                nil,
-		autoReconnect:     true,
-		listeners:         make(map[string]Listener),
-		subscribeResultCb: make(map[string]jsonChan),
-		requestResultCb:   make(map[string]jsonChan),
-		subscribedTopic:   make(map[string]bool),
-		mutex:             &sync.RWMutex{},
-	}
-
-	if err := m.connect(); err != nil {
+		HeartbeatInterval:   5 * time.Second,
+		ReceiveTimeout:      10 * time.Second,
+		ws:                  nil,
+		endpoints:           newEndpointSet(o.endpoints, o.endpointFailover),
+		dialer:              o.network.websocketDialer(),
+		supervisor:          newSupervisor(o),
+		listeners:           make(map[string]map[*Subscription]RawListener),
+		subscribeResultCb:   make(map[string]*subscribeAck),
+		unsubscribeResultCb: make(map[string]replyChan),
+		requestResultCb:     make(map[string]replyChan),
+		subscribedTopic:     make(map[string]bool),
+		mutex:               &sync.RWMutex{},
+		onReconnect:         onReconnect,
+		logger:              o.logger,
+		metrics:             o.metrics,
+	}
+
+	// 创建时依次尝试每个入口, 直到连接成功
+	for range o.endpoints {
+		endpoint := m.endpoints.get()
+		if err = m.connect(); err == nil {
+			break
+		}
+		m.endpoints.skip(endpoint)
+	}
+	if err != nil {
 		return nil, err
 	}
+	m.supervisor.setState(StateConnected, nil)
 
 	return m, nil
 }
 
-// connect 连接
+// conn 当前连接
+func (m *Market) conn() *SafeWebSocket {
+	m.wsMutex.Lock()
+	defer m.wsMutex.Unlock()
+	return m.ws
+}
+
+// connect 连接当前入口, 连续失败达到上限后切换到下一个入口
 func (m *Market) connect() error {
-	debug.Println("connecting")
-	ws, err := NewSafeWebSocket(Endpoint)
+	endpoint := m.endpoints.get()
+	m.logger.Debug("connecting", "endpoint", endpoint)
+	ws, err := newSafeWebSocket(endpoint, m.dialer, m.logger)
 	if err != nil {
+		if next, switched := m.endpoints.fail(); switched {
+			m.logger.Warn("endpoint failover", "from", endpoint, "to", next)
+		}
 		return err
 	}
+	m.endpoints.succeed()
+	atomic.StoreInt64(&m.lastPing, getUinxMillisecond())
+	m.wsMutex.Lock()
 	m.ws = ws
-	m.lastPing = getUinxMillisecond()
-	debug.Println("connected")
+	m.wsMutex.Unlock()
+	m.logger.Info("connected", "endpoint", endpoint)
 
-	m.handleMessageLoop()
-	m.keepAlive()
+	m.handleMessageLoop(ws)
+	m.keepAlive(ws)
 
 	return nil
 }
 
-// reconnect 重新连接
-func (m *Market) reconnect() error {
-	debug.Println("reconnecting after 1s")
-	time.Sleep(time.Second)
-
-	if err := m.connect(); err != nil {
-		debug.Println(err)
-		return err
+// resubscribe 重连成功后重新订阅所有主题
+func (m *Market) resubscribe() {
+	if m.onReconnect != nil {
+		m.onReconnect(m)
 	}
 
-	// 重新订阅
-	var listeners = make(map[string]Listener)
-	for k, v := range m.listeners {
-		listeners[k] = v
+	// 重新订阅, 断线前未完成的订阅以新连接上的订阅结果为准
+	m.mutex.Lock()
+	pending := m.subscribeResultCb
+	m.subscribeResultCb = make(map[string]*subscribeAck)
+	// 新连接上没有任何订阅, 断线前未收到确认的取消订阅视为成功
+	for topic, c := range m.unsubscribeResultCb {
+		select {
+		case c <- &marketReply{}:
+		default:
+		}
+		delete(m.unsubscribeResultCb, topic)
 	}
-	for topic, listener := range listeners {
+	var topics []string
+	for topic := range m.listeners {
+		topics = append(topics, topic)
 		delete(m.subscribedTopic, topic)
-		m.Subscribe(topic, listener)
 	}
-	return nil
+	m.mutex.Unlock()
+
+	acks := make([]*subscribeAck, len(topics))
+	for i, topic := range topics {
+		acks[i] = m.subscribeAsync(topic)
+	}
+	for i, topic := range topics {
+		ack := acks[i]
+		<-ack.done
+		if ack.err != nil {
+			m.logger.Error("resubscribe failed", "topic", topic, "err", ack.err)
+			m.reportError(topic, ack.err)
+		}
+		if old, ok := pending[topic]; ok {
+			old.finish(ack.err, func() {})
+			delete(pending, topic)
+		}
+	}
+	for _, old := range pending {
+		old.finish(UnsubscribedError, func() {})
+	}
+}
+
+// OnStateChange 添加连接状态监听器, 可据此在行情中断时暂停交易
+func (m *Market) OnStateChange(listener StateListener) {
+	m.supervisor.OnStateChange(listener)
+}
+
+// State 当前连接状态
+func (m *Market) State() ConnState {
+	return m.supervisor.State()
 }
 
 // sendMessage 发送消息
 func (m *Market) sendMessage(data interface{}) error {
+	return m.send(m.conn(), data)
+}
+
+// send 在指定连接上发送消息
+func (m *Market) send(ws *SafeWebSocket, data interface{}) error {
 	b, err := json.Marshal(data)
 	if err != nil {
-		return nil
+		return err
 	}
-	debug.Println("sendMessage", string(b))
-	m.ws.Send(b)
-	return nil
+	m.logger.Debug("sendMessage", "message", string(b))
+	return ws.Send(b)
 }
 
 // handleMessageLoop 处理消息循环
-func (m *Market) handleMessageLoop() {
-	m.ws.Listen(func(buf []byte) {
-		msg, err := unGzipData(buf)
-		debug.Println("readMessage", string(msg))
+func (m *Market) handleMessageLoop(ws *SafeWebSocket) {
+	ws.Listen(func(buf []byte) {
+		b, err := gunzip(buf)
 		if err != nil {
-			debug.Println(err)
+			m.logger.Error("ungzip message failed", "err", err)
 			return
 		}
-		json, err := simplejson.NewJson(msg)
-		if err != nil {
-			debug.Println(err)
+		defer releaseBuffer(b)
+		msg := b.Bytes()
+		m.logger.Debug("readMessage", "message", logBytes(msg))
+
+		var header marketHeader
+		if err := peekHeader(msg, &header); err != nil {
+			m.logger.Error("parse message failed", "err", err)
+			m.metrics.IncDecodeFailure("")
+			return
+		}
+
+		// 处理订阅消息
+		if ch := header.Ch; ch != "" && header.Rep == "" {
+			m.dispatch(ch, msg)
 			return
 		}
 
 		// 处理ping消息
-		if ping := json.Get("ping").MustInt64(); ping > 0 {
+		if ping := header.Ping; ping > 0 {
 			m.handlePing(pingData{Ping: ping})
 			return
 		}
 
 		// 处理pong消息
-		if pong := json.Get("pong").MustInt64(); pong > 0 {
-			m.lastPing = pong
+		if pong := header.Pong; pong > 0 {
+			atomic.StoreInt64(&m.lastPing, pong)
+			m.metrics.ObservePingRTT(time.Duration(getUinxMillisecond()-pong) * time.Millisecond)
 			return
 		}
 
-		// 处理订阅消息
-		if ch := json.Get("ch").MustString(); ch != "" {
-			m.mutex.RLock()
-			defer m.mutex.RUnlock()
-			listener, ok := m.listeners[ch]
-			if ok {
-				debug.Println("handleSubscribe", json)
-				listener(ch, json)
-			}
-
+		// 处理订阅成功通知
+		if subbed := header.Subbed; subbed != "" {
+			m.ackSubscribe(subbed, header.ErrMsg)
 			return
 		}
 
-		// 处理订阅成功通知
-		if subbed := json.Get("subbed").MustString(); subbed != "" {
-			c, ok := m.subscribeResultCb[subbed]
-			if ok {
-				c <- json
-			}
+		// 处理取消订阅成功通知
+		if unsubbed := header.Unsubbed; unsubbed != "" {
+			m.deliver(m.unsubscribeResultCb, unsubbed, &header, msg)
 			return
 		}
 
 		// 请求行情结果
-		if rep, id := json.Get("rep").MustString(), json.Get("id").MustString(); rep != "" && id != "" {
-			c, ok := m.requestResultCb[id]
-			if ok {
-				c <- json
-			}
+		if rep, id := header.Rep, header.ID; rep != "" && id != "" {
+			m.deliver(m.requestResultCb, id, &header, msg)
 			return
 		}
 
 		// 处理错误消息
-		if status := json.Get("status").MustString(); status == "error" {
-			// 判断是否为订阅失败
-			id := json.Get("id").MustString()
-			c, ok := m.subscribeResultCb[id]
-			if ok {
-				c <- json
+		if header.Status == "error" {
+			// 判断是否为订阅或取消订阅失败
+			id := header.ID
+			if strings.HasPrefix(id, reqIDPrefix) {
+				m.deliver(m.requestResultCb, id, &header, msg)
+				return
+			}
+			if strings.HasPrefix(id, unsubIDPrefix) {
+				m.deliver(m.unsubscribeResultCb, strings.TrimPrefix(id, unsubIDPrefix), &header, msg)
+				return
 			}
+			m.ackSubscribe(id, header.ErrMsg)
 			return
 		}
 	})
 }
 
+// dispatch 将推送交给主题的所有监听器
+func (m *Market) dispatch(ch string, msg []byte) {
+	m.metrics.ObserveMessage(ch, len(msg))
+	m.mutex.RLock()
+	listeners := make([]RawListener, 0, len(m.listeners[ch]))
+	for _, listener := range m.listeners[ch] {
+		listeners = append(listeners, listener)
+	}
+	m.mutex.RUnlock()
+
+	if len(listeners) > 0 {
+		m.logger.Debug("handleSubscribe", "topic", ch, "listeners", len(listeners))
+	}
+	for _, listener := range listeners {
+		listener(ch, msg)
+	}
+}
+
 // keepAlive 保持活跃
-func (m *Market) keepAlive() {
-	m.ws.KeepAlive(m.HeartbeatInterval, func() {
+func (m *Market) keepAlive(ws *SafeWebSocket) {
+	ws.KeepAlive(m.HeartbeatInterval, func() {
 		var t = getUinxMillisecond()
-		m.sendMessage(pingData{Ping: t})
+		m.send(ws, pingData{Ping: t})
 
-		// 检查上次ping时间，如果超过20秒无响应，重新连接
-		tr := time.Duration(math.Abs(float64(t - m.lastPing)))
+		// 检查上次ping时间，如果超过2倍心跳间隔无响应，断开连接交给Loop重连
+		lastPing := atomic.LoadInt64(&m.lastPing)
+		tr := time.Duration(math.Abs(float64(t-lastPing))) * time.Millisecond
 		if tr >= m.HeartbeatInterval*2 {
-			debug.Println("no ping max delay", tr, m.HeartbeatInterval*2, t, m.lastPing)
-			if m.autoReconnect {
-				err := m.reconnect()
-				if err != nil {
-					debug.Println(err)
-				}
-			}
+			m.logger.Warn("no ping max delay", "delay", tr, "max", m.HeartbeatInterval*2, "now", t, "lastPing", lastPing)
+			ws.fail(HeartbeatTimeoutError)
 		}
 	})
 }
 
 // handlePing 处理Ping
 func (m *Market) handlePing(ping pingData) (err error) {
-	debug.Println("handlePing", ping)
-	m.lastPing = ping.Ping
+	m.logger.Debug("handlePing", "ping", ping.Ping)
+	atomic.StoreInt64(&m.lastPing, ping.Ping)
 	var pong = pongData{Pong: ping.Ping}
 	err = m.sendMessage(pong)
 	if err != nil {
@@ -271,97 +407,424 @@ func (m *Market) handlePing(ping pingData) (err error) {
 	return nil
 }
 
-// Subscribe 订阅
+// Subscribe 订阅, 并替换该主题已有的全部监听器
+// 同一主题需要多个独立监听器时使用Listen
 func (m *Market) Subscribe(topic string, listener Listener) error {
+	_, err := m.SubscribeAsync(topic, listener).Wait(context.Background())
+	return err
+}
+
+// SubscribeAsync 异步订阅, 并替换该主题已有的全部监听器
+func (m *Market) SubscribeAsync(topic string, listener Listener) *SubscribeFuture {
+	return m.listenAsync(newSubscription(m, topic), m.jsonListener(listener), true)
+}
+
+// Listen 为主题添加一个监听器, 返回订阅句柄
+// 主题首次被监听时发送订阅指令并等待结果, 最多等待ReceiveTimeout
+func (m *Market) Listen(topic string, listener Listener) (*Subscription, error) {
+	return m.ListenAsync(topic, listener).Wait(context.Background())
+}
+
+// ListenAsync 异步为主题添加一个监听器, 订阅结果通过SubscribeFuture获取
+// 订阅完成前监听器就已生效
+func (m *Market) ListenAsync(topic string, listener Listener) *SubscribeFuture {
+	return m.listenAsync(newSubscription(m, topic), m.jsonListener(listener), false)
+}
+
+// ListenRaw 为主题添加一个接收消息原文的监听器, 省去解析为simplejson的开销
+func (m *Market) ListenRaw(topic string, listener RawListener) (*Subscription, error) {
+	return m.ListenRawAsync(topic, listener).Wait(context.Background())
+}
+
+// ListenRawAsync 异步为主题添加一个接收消息原文的监听器
+func (m *Market) ListenRawAsync(topic string, listener RawListener) *SubscribeFuture {
+	return m.listenAsync(newSubscription(m, topic), listener, false)
+}
+
+// SubscribeMany 同时为多个主题添加监听器, 订阅指令并行发送, 按topics的顺序返回每个主题的结果
+// ctx结束时未完成的主题返回ctx.Err(), 这些订阅仍在后台继续
+func (m *Market) SubscribeMany(ctx context.Context, topics []string, listener Listener) []SubscribeResult {
+	futures := make([]*SubscribeFuture, len(topics))
+	for i, topic := range topics {
+		futures[i] = m.ListenAsync(topic, listener)
+	}
+	return waitSubscribeFutures(ctx, futures)
+}
+
+// listenAsync 添加订阅句柄并订阅主题, replace为true时先移除该主题已有的全部监听器
+func (m *Market) listenAsync(sub *Subscription, listener RawListener, replace bool) *SubscribeFuture {
+	m.addListener(sub, listener, replace)
+	return m.awaitSubscribe(sub)
+}
+
+// addListener 添加订阅句柄, 不发送订阅指令, replace为true时先移除该主题已有的全部监听器
+func (m *Market) addListener(sub *Subscription, listener RawListener, replace bool) {
+	topic := sub.topic
+
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	debug.Println("subscribe", topic)
+	if replace {
+		for old := range m.listeners[topic] {
+			old.close()
+		}
+		delete(m.listeners, topic)
+	}
+	if m.listeners[topic] == nil {
+		m.listeners[topic] = make(map[*Subscription]RawListener)
+	}
+	m.listeners[topic][sub] = listener
+}
+
+// awaitSubscribe 订阅已添加句柄的主题, 订阅失败时通过句柄的所有者移除句柄
+// 句柄在MarketPool中可能已被迁移到其他连接, 由MarketPool负责找到它
+func (m *Market) awaitSubscribe(sub *Subscription) *SubscribeFuture {
+	f := newSubscribeFuture(sub.topic)
+	ack := m.subscribeAsync(sub.topic)
+	go func() {
+		<-ack.done
+		if ack.err != nil {
+			sub.owner.removeSubscription(sub)
+			f.resolve(nil, ack.err)
+			return
+		}
+		f.resolve(sub, nil)
+	}()
+	return f
+}
 
-	var isNew = false
+// subscribe 发送订阅指令并等待订阅结果
+func (m *Market) subscribe(topic string) error {
+	ack := m.subscribeAsync(topic)
+	<-ack.done
+	return ack.err
+}
 
-	// 如果未曾发送过订阅指令，则发送，并等待订阅操作结果，否则直接返回
-	if _, ok := m.subscribedTopic[topic]; !ok {
-		m.subscribeResultCb[topic] = make(jsonChan)
-		m.sendMessage(subData{ID: topic, Sub: topic})
-		isNew = true
-	} else {
-		debug.Println("send subscribe before, reset listener only")
+// subscribeAsync 发送订阅指令, 不持有锁等待结果, 超过ReceiveTimeout返回SubscribeTimeoutError
+// 已订阅的主题直接成功, 订阅中的主题共享同一个结果
+func (m *Market) subscribeAsync(topic string) *subscribeAck {
+	m.subMutex.Lock()
+	defer m.subMutex.Unlock()
+	m.mutex.Lock()
+	if ack, ok := m.subscribeResultCb[topic]; ok {
+		m.mutex.Unlock()
+		m.logger.Debug("subscribe in flight, wait for the same ack", "topic", topic)
+		return ack
+	}
+	// 如果已经发送过订阅指令则直接返回
+	if _, ok := m.subscribedTopic[topic]; ok {
+		m.mutex.Unlock()
+		m.logger.Debug("send subscribe before, add listener only", "topic", topic)
+		return subscribedAck
 	}
 
-	m.listeners[topic] = listener
+	m.logger.Debug("subscribe", "topic", topic)
+	ack := newSubscribeAck(topic)
+	ack.deadline(m.ReceiveTimeout, func() {
+		m.finishSubscribe(ack, SubscribeTimeoutError)
+	})
+	m.subscribeResultCb[topic] = ack
 	m.subscribedTopic[topic] = true
+	m.mutex.Unlock()
+
+	if err := m.sendMessage(subData{ID: topic, Sub: topic}); err != nil {
+		m.finishSubscribe(ack, err)
+	}
+	return ack
+}
 
-	if isNew {
-		var json = <-m.subscribeResultCb[topic]
-		// 判断订阅结果，如果出错则返回出错信息
-		if msg, err := json.Get("err-msg").String(); err == nil {
-			return fmt.Errorf(msg)
+// ackSubscribe 处理服务器返回的订阅结果
+func (m *Market) ackSubscribe(topic string, errMsg string) {
+	m.mutex.RLock()
+	ack := m.subscribeResultCb[topic]
+	m.mutex.RUnlock()
+	if ack == nil {
+		return
+	}
+
+	// 判断订阅结果，如果出错则返回出错信息
+	var err error
+	if errMsg != "" {
+		err = errors.New(errMsg)
+	}
+	m.finishSubscribe(ack, err)
+}
+
+// finishSubscribe 结束一次订阅, 失败时清除订阅状态以便重试
+func (m *Market) finishSubscribe(ack *subscribeAck, err error) {
+	ack.finish(err, func() {
+		m.mutex.Lock()
+		current := m.subscribeResultCb[ack.topic] == ack
+		if current {
+			delete(m.subscribeResultCb, ack.topic)
+			if err != nil {
+				delete(m.subscribedTopic, ack.topic)
+			}
 		}
+		m.mutex.Unlock()
+
+		if current {
+			m.metrics.SetSubscribed(ack.topic, err == nil)
+		}
+	})
+}
+
+// deliver 将结果投递给等待中的调用方, 不阻塞, msg会被复制
+func (m *Market) deliver(cbs map[string]replyChan, key string, header *marketHeader, msg []byte) {
+	m.mutex.RLock()
+	c, ok := cbs[key]
+	m.mutex.RUnlock()
+	if !ok {
+		return
 	}
-	return nil
+	reply := &marketReply{header: *header, raw: append([]byte(nil), msg...)}
+	select {
+	case c <- reply:
+	default:
+	}
+}
+
+// removeSubscription 移除订阅句柄, 主题没有监听器后取消订阅
+func (m *Market) removeSubscription(sub *Subscription) error {
+	_, err := m.remove(sub)
+	return err
+}
+
+// remove 移除订阅句柄, 句柄不在该连接上时返回false
+// 判断是否为最后一个监听器和发送unsub在同一次持有subMutex时完成, 期间新加入的监听器的sub指令排在unsub之后
+func (m *Market) remove(sub *Subscription) (bool, error) {
+	m.subMutex.Lock()
+	m.mutex.Lock()
+	listeners, ok := m.listeners[sub.topic]
+	if !ok {
+		m.mutex.Unlock()
+		m.subMutex.Unlock()
+		return false, nil
+	}
+	if _, ok := listeners[sub]; !ok {
+		m.mutex.Unlock()
+		m.subMutex.Unlock()
+		return false, nil
+	}
+	delete(listeners, sub)
+	sub.close()
+	if len(listeners) > 0 {
+		m.mutex.Unlock()
+		m.subMutex.Unlock()
+		return true, nil
+	}
+	delete(m.listeners, sub.topic)
+	return true, m.unsubscribeLocked(sub.topic)
+}
+
+// topicCount 有监听器的主题数量
+func (m *Market) topicCount() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return len(m.listeners)
+}
+
+// hasTopic 主题是否有监听器
+func (m *Market) hasTopic(topic string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	_, ok := m.listeners[topic]
+	return ok
+}
+
+// topics 有监听器的所有主题
+func (m *Market) topics() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	topics := make([]string, 0, len(m.listeners))
+	for topic := range m.listeners {
+		topics = append(topics, topic)
+	}
+	return topics
 }
 
-// Unsubscribe 取消订阅
-func (m *Market) Unsubscribe(topic string) {
-	debug.Println("unSubscribe", topic)
-	// 火币网没有提供取消订阅的接口，只能删除监听器
+// detach 移出主题的全部监听器及未完成的订阅, 不通知服务器, 仅用于重连后服务器端已无订阅时迁移主题
+func (m *Market) detach(topic string) (map[*Subscription]RawListener, *subscribeAck) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	listeners := m.listeners[topic]
+	pending := m.subscribeResultCb[topic]
 	delete(m.listeners, topic)
+	delete(m.subscribedTopic, topic)
+	delete(m.subscribeResultCb, topic)
+	return listeners, pending
+}
+
+// attach 接收从其他连接迁移来的监听器, 不发送订阅指令, 之后需调用subscribe订阅主题
+func (m *Market) attach(topic string, listeners map[*Subscription]RawListener) {
+	if len(listeners) == 0 {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.listeners[topic] == nil {
+		m.listeners[topic] = make(map[*Subscription]RawListener)
+	}
+	for sub, listener := range listeners {
+		m.listeners[topic][sub] = listener
+	}
+}
+
+// reportError 将错误投递给主题的所有订阅句柄
+func (m *Market) reportError(topic string, err error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for sub := range m.listeners[topic] {
+		sub.reportError(err)
+	}
+}
+
+// Unsubscribe 取消订阅, 该主题的所有订阅句柄都会失效
+// 向服务器发送unsub指令并等待unsubbed确认, 重连后不再重新订阅该主题
+func (m *Market) Unsubscribe(topic string) error {
+	m.subMutex.Lock()
+	m.mutex.Lock()
+	for sub := range m.listeners[topic] {
+		sub.close()
+	}
+	delete(m.listeners, topic)
+	return m.unsubscribeLocked(topic)
+}
+
+// unsubscribeLocked 清除主题的订阅状态并发送unsub指令, 调用方需持有m.subMutex和m.mutex, 返回前释放
+// 发送队列已满时发送会阻塞, 因此先释放mutex, 不阻塞推送的分发; unsub在持有subMutex时放入发送队列,
+// 之后再订阅该主题时sub指令一定排在unsub之后
+func (m *Market) unsubscribeLocked(topic string) error {
+	m.logger.Debug("unSubscribe", "topic", topic)
+
+	_, subscribed := m.subscribedTopic[topic]
+	delete(m.subscribedTopic, topic)
+	pending := m.subscribeResultCb[topic]
+	delete(m.subscribeResultCb, topic)
+	m.metrics.SetSubscribed(topic, false)
+
+	// 未发送过订阅指令则无需通知服务器
+	if !subscribed {
+		m.mutex.Unlock()
+		m.subMutex.Unlock()
+		return nil
+	}
+
+	c := make(replyChan, 1)
+	m.unsubscribeResultCb[topic] = c
+	m.mutex.Unlock()
+	err := m.sendMessage(unsubData{ID: unsubIDPrefix + topic, Unsub: topic})
+	m.subMutex.Unlock()
+
+	// 结束仍在等待中的订阅
+	if pending != nil {
+		m.finishSubscribe(pending, UnsubscribedError)
+	}
+
+	defer func() {
+		m.mutex.Lock()
+		if m.unsubscribeResultCb[topic] == c {
+			delete(m.unsubscribeResultCb, topic)
+		}
+		m.mutex.Unlock()
+	}()
+
+	if err != nil {
+		return err
+	}
+
+	var reply *marketReply
+	select {
+	case reply = <-c:
+	case <-time.After(m.ReceiveTimeout):
+		return UnsubscribeTimeoutError
+	}
+
+	// 判断取消订阅结果，如果出错则返回出错信息
+	if reply.header.ErrMsg != "" {
+		return errors.New(reply.header.ErrMsg)
+	}
+	return nil
 }
 
 // Request 请求行情信息
 func (m *Market) Request(req string) (*simplejson.Json, error) {
-	var id = getRandomString(10)
-	m.requestResultCb[id] = make(jsonChan)
+	return requestJSON(m.request(reqData{Req: req}))
+}
+
+// RequestRange 请求指定时间范围内的行情信息, 如K线的历史数据
+func (m *Market) RequestRange(req string, from, to time.Time) (*simplejson.Json, error) {
+	return requestJSON(m.request(reqData{Req: req, From: from.Unix(), To: to.Unix()}))
+}
 
-	if err := m.sendMessage(reqData{Req: req, ID: id}); err != nil {
+// requestJSON 将请求结果解析为simplejson, 请求出错时同时返回结果和错误
+func requestJSON(raw []byte, err error) (*simplejson.Json, error) {
+	if raw == nil {
 		return nil, err
 	}
-	var json = <-m.requestResultCb[id]
+	json, e := simplejson.NewJson(raw)
+	if e != nil {
+		return nil, e
+	}
+	return json, err
+}
 
-	delete(m.requestResultCb, id)
+// request 发送请求指令并等待结果, 超过ReceiveTimeout返回RequestTimeoutError, 频率超限返回RequestRateLimitError
+// 服务器返回错误时同时返回消息原文
+func (m *Market) request(data reqData) ([]byte, error) {
+	data.ID = reqIDPrefix + getRandomString(10)
+	c := make(replyChan, 1)
+	m.mutex.Lock()
+	m.requestResultCb[data.ID] = c
+	m.mutex.Unlock()
+	defer func() {
+		m.mutex.Lock()
+		delete(m.requestResultCb, data.ID)
+		m.mutex.Unlock()
+	}()
+
+	if err := m.sendMessage(data); err != nil {
+		return nil, err
+	}
+
+	var reply *marketReply
+	select {
+	case reply = <-c:
+	case <-time.After(m.ReceiveTimeout):
+		return nil, RequestTimeoutError
+	}
 
 	// 判断是否出错
-	if msg := json.Get("err-msg").MustString(); msg != "" {
-		return json, fmt.Errorf(msg)
+	if reply.header.ErrCode == errCodeTooManyRequests {
+		return reply.raw, RequestRateLimitError
+	}
+	if reply.header.ErrMsg != "" {
+		return reply.raw, errors.New(reply.header.ErrMsg)
 	}
-	return json, nil
+	return reply.raw, nil
 }
 
-// Loop 进入循环
+// Loop 进入循环, 连接断开后按退避策略重连, 直到Close或重连次数用尽
+// 断线重连只在Loop中进行, 不调用Loop则不会重连
 func (m *Market) Loop() {
-	debug.Println("startLoop")
-	for {
-		err := m.ws.Loop()
-		if err != nil {
-			debug.Println(err)
-			if err == SafeWebSocketDestroyError {
-				break
-			} else if m.autoReconnect {
-				m.reconnect()
-			} else {
-				break
-			}
-		}
-	}
-	debug.Println("endLoop")
+	m.logger.Debug("startLoop")
+	m.supervisor.run(m.conn, m.connect, m.resubscribe)
+	m.logger.Debug("endLoop")
 }
 
-// ReConnect 重新连接
+// ReConnect 断开当前连接, 由Loop重新连接
 func (m *Market) ReConnect() (err error) {
-	debug.Println("reconnect")
-	m.autoReconnect = true
-	if err = m.ws.Destroy(); err != nil {
-		return err
+	m.logger.Info("reconnect")
+	if m.supervisor.closed() {
+		return SafeWebSocketDestroyError
 	}
-	return m.reconnect()
+	m.conn().fail(reconnectRequestedError)
+	return nil
 }
 
-// Close 关闭连接
+// Close 关闭连接并等待后台协程退出, 不再重连
+// 不能在监听器中调用, 否则会等待自身退出, 需要时在新的协程中调用
 func (m *Market) Close() error {
-	debug.Println("close")
-	m.autoReconnect = false
-	if err := m.ws.Destroy(); err != nil {
-		return err
-	}
-	return nil
+	m.logger.Info("close")
+	m.supervisor.close()
+	return m.conn().Destroy()
 }